@@ -19,6 +19,7 @@ import io.nekohasekai.sagernet.utils.Subnet
 import libcore.*
 import moe.matsuri.nb4a.net.LocalResolverImpl
 import moe.matsuri.nb4a.proxy.neko.needBypassRootUid
+import org.json.JSONObject
 import android.net.VpnService as BaseVpnService
 
 class VpnService : BaseVpnService(),
@@ -189,6 +190,25 @@ class VpnService : BaseVpnService(),
             } else {
                 Logs.d("Add allow: ${added.joinToString(", ")}")
             }
+        } else {
+            // per-app options of the tun inbound, uids are translated by libcore
+            val platformOptions = JSONObject(tunPlatformOptionsJson)
+            val includePackage = platformOptions.optJSONArray("include_package")
+                ?.let { array -> List(array.length()) { array.getString(it) } }.orEmpty()
+            val excludePackage = platformOptions.optJSONArray("exclude_package")
+                ?.let { array -> List(array.length()) { array.getString(it) } }.orEmpty()
+            val allow = includePackage.isNotEmpty()
+            (if (allow) includePackage + packageName else excludePackage - packageName).forEach {
+                try {
+                    if (allow) {
+                        builder.addAllowedApplication(it)
+                    } else {
+                        builder.addDisallowedApplication(it)
+                    }
+                } catch (ex: PackageManager.NameNotFoundException) {
+                    Logs.w(ex)
+                }
+            }
         }
 
         if (Build.VERSION.SDK_INT >= Build.VERSION_CODES.Q && DataStore.appendHttpProxy) {
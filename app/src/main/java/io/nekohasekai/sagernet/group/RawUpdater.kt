@@ -30,6 +30,7 @@ import org.json.JSONTokener
 import org.yaml.snakeyaml.TypeDescription
 import org.yaml.snakeyaml.Yaml
 import org.yaml.snakeyaml.error.YAMLException
+import java.io.File
 import java.io.StringReader
 
 @Suppress("EXPERIMENTAL_API_USAGE")
@@ -56,6 +57,8 @@ object RawUpdater : GroupUpdater() {
 
             val response = Libcore.newHttpClient().apply {
                 trySocks5(DataStore.mixedPort)
+                // conditional requests, unchanged subscriptions are not downloaded again
+                useCache(File(app.cacheDir, "subscription").absolutePath)
                 when (DataStore.appTLSVersion) {
                     "1.3" -> restrictedTLS()
                 }
@@ -279,7 +279,7 @@ class AssetsActivity : ThemedActivity() {
         }
 
         try {
-            var response = client.newRequest().apply {
+            val response = client.newRequest().apply {
                 setURL("https://api.github.com/repos/$repo/releases/latest")
             }.execute()
 
@@ -298,14 +298,16 @@ class AssetsActivity : ThemedActivity() {
                 ?: error("File $fileName not found in release ${release["url"]}")
             val browserDownloadUrl = assetToDownload.getStr("browser_download_url")
 
-            response = client.newRequest().apply {
-                setURL(browserDownloadUrl)
-            }.execute()
+            val digest = assetToDownload.optString("digest")
+                .takeIf { it.startsWith("sha256:") }?.removePrefix("sha256:") ?: ""
 
             val cacheFile = File(file.parentFile, file.name + ".tmp")
             cacheFile.parentFile?.mkdirs()
 
-            response.writeTo(cacheFile.canonicalPath)
+            // resumed if interrupted
+            client.newRequest().apply {
+                setURL(browserDownloadUrl)
+            }.download(cacheFile.canonicalPath, digest, null)
 
             if (fileName.endsWith(".xz")) {
                 Libcore.unxz(cacheFile.absolutePath, file.absolutePath)
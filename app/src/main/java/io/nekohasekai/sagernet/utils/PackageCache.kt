@@ -8,6 +8,7 @@ import android.content.pm.PackageManager
 import io.nekohasekai.sagernet.ktx.app
 import io.nekohasekai.sagernet.ktx.listenForPackageChanges
 import kotlinx.coroutines.runBlocking
+import libcore.Libcore
 import kotlinx.coroutines.sync.Mutex
 import kotlinx.coroutines.sync.withLock
 import moe.matsuri.nb4a.plugin.Plugins
@@ -30,6 +31,7 @@ object PackageCache {
         app.listenForPackageChanges(false) {
             reload()
             labelMap.clear()
+            Libcore.clearPackageNameCache()
         }
         loaded.unlock()
     }
@@ -2,6 +2,8 @@ package moe.matsuri.nb4a
 
 import android.content.Context
 import android.net.ConnectivityManager
+import android.net.NetworkCapabilities
+import android.net.wifi.WifiInfo
 import android.net.wifi.WifiManager
 import android.os.Build
 import android.os.Build.VERSION_CODES
@@ -13,14 +15,23 @@ import io.nekohasekai.sagernet.database.SagerDatabase
 import io.nekohasekai.sagernet.ktx.Logs
 import io.nekohasekai.sagernet.ktx.app
 import io.nekohasekai.sagernet.ktx.runOnDefaultDispatcher
+import io.nekohasekai.sagernet.utils.DefaultNetworkListener
 import io.nekohasekai.sagernet.utils.PackageCache
 import libcore.BoxPlatformInterface
+import libcore.InterfaceUpdateListener
+import libcore.Libcore
+import libcore.NetworkInterfaceIterator
+import libcore.WIFIState
 import libcore.NB4AInterface
 import moe.matsuri.nb4a.utils.LibcoreUtil
+import kotlinx.coroutines.runBlocking
 import java.net.InetSocketAddress
+import java.net.NetworkInterface
 
 class NativeInterface : BoxPlatformInterface, NB4AInterface {
 
+    private val interfaceMonitorKey = Any()
+
     //  libbox interface
 
     override fun autoDetectInterfaceControl(fd: Int) {
@@ -67,12 +78,100 @@ class NativeInterface : BoxPlatformInterface, NB4AInterface {
         return PackageCache[packageName] ?: 0
     }
 
+    override fun packageNamesByUidRange(start: Int, end: Int): String {
+        PackageCache.awaitLoadSync()
+        return PackageCache.packageMap.filterValues { it in start..end }.keys.joinToString("\n")
+    }
+
     // TODO: 'getter for connectionInfo: WifiInfo!' is deprecated
-    override fun wifiState(): String {
+    override fun readWIFIState(): WIFIState {
         val wifiManager =
             app.applicationContext.getSystemService(Context.WIFI_SERVICE) as WifiManager
         val connectionInfo = wifiManager.connectionInfo
-        return "${connectionInfo.ssid},${connectionInfo.bssid}"
+        return WIFIState().apply {
+            connected = connectionInfo != null && connectionInfo.networkId != -1
+            if (!connected) return@apply
+            ssid = connectionInfo.ssid.removeSurrounding("\"")
+            if (ssid == WifiManager.UNKNOWN_SSID) ssid = ""
+            bssid = connectionInfo.bssid ?: ""
+            frequency = connectionInfo.frequency
+            if (Build.VERSION.SDK_INT >= VERSION_CODES.S) {
+                security = when (connectionInfo.currentSecurityType) {
+                    WifiInfo.SECURITY_TYPE_OPEN -> "open"
+                    WifiInfo.SECURITY_TYPE_WEP -> "wep"
+                    WifiInfo.SECURITY_TYPE_PSK -> "psk"
+                    WifiInfo.SECURITY_TYPE_SAE -> "sae"
+                    WifiInfo.SECURITY_TYPE_OWE -> "owe"
+                    WifiInfo.SECURITY_TYPE_EAP,
+                    WifiInfo.SECURITY_TYPE_EAP_WPA3_ENTERPRISE,
+                    WifiInfo.SECURITY_TYPE_EAP_WPA3_ENTERPRISE_192_BIT -> "eap"
+                    else -> ""
+                }
+            }
+        }
+    }
+
+    override fun startDefaultInterfaceMonitor(listener: InterfaceUpdateListener) {
+        runBlocking {
+            // libcore starts one listener for all instances, proxies of it are not the same object
+            DefaultNetworkListener.start(interfaceMonitorKey) { network ->
+                val link = network?.let { SagerNet.connectivity.getLinkProperties(it) }
+                val interfaceName = link?.interfaceName
+                if (interfaceName == null) {
+                    listener.updateDefaultInterface("", -1, false, false)
+                    return@start
+                }
+                val interfaceIndex = runCatching {
+                    NetworkInterface.getByName(interfaceName)?.index
+                }.getOrNull() ?: -1
+                val capabilities = SagerNet.connectivity.getNetworkCapabilities(network)
+                val isExpensive = capabilities?.hasCapability(
+                    NetworkCapabilities.NET_CAPABILITY_NOT_METERED
+                ) == false
+                val isConstrained = Build.VERSION.SDK_INT >= VERSION_CODES.N &&
+                        SagerNet.connectivity.restrictBackgroundStatus == ConnectivityManager.RESTRICT_BACKGROUND_STATUS_ENABLED
+                listener.updateDefaultInterface(
+                    interfaceName, interfaceIndex, isExpensive, isConstrained
+                )
+                // roaming only changes the capabilities
+                listener.updateWIFIState()
+            }
+        }
+    }
+
+    override fun closeDefaultInterfaceMonitor(listener: InterfaceUpdateListener) {
+        runBlocking {
+            DefaultNetworkListener.stop(interfaceMonitorKey)
+        }
+    }
+
+    override fun getInterfaces(): NetworkInterfaceIterator {
+        val interfaces = NetworkInterface.getNetworkInterfaces()?.toList().orEmpty().map {
+            libcore.NetworkInterface().apply {
+                index = it.index
+                mtu = runCatching { it.mtu }.getOrDefault(0)
+                name = it.name
+                var flags = 0
+                if (it.isUp) flags = flags or Libcore.InterfaceFlagUp
+                if (it.interfaceAddresses.any { address -> address.broadcast != null }) {
+                    flags = flags or Libcore.InterfaceFlagBroadcast
+                }
+                if (it.isLoopback) flags = flags or Libcore.InterfaceFlagLoopback
+                if (it.isPointToPoint) flags = flags or Libcore.InterfaceFlagPointToPoint
+                if (it.supportsMulticast()) flags = flags or Libcore.InterfaceFlagMulticast
+                this.flags = flags
+                addresses = it.interfaceAddresses.joinToString("\n") { address ->
+                    // drop the scope of link-local addresses
+                    address.address.hostAddress!!.substringBefore('%') + "/" + address.networkPrefixLength
+                }
+            }
+        }
+        return object : NetworkInterfaceIterator {
+            private val iterator = interfaces.iterator()
+            override fun len() = interfaces.size
+            override fun hasNext() = iterator.hasNext()
+            override fun next(): libcore.NetworkInterface = iterator.next()
+        }
     }
 
     // nb4a interface
@@ -13,13 +13,17 @@ import (
 
 	"github.com/matsuridayo/libneko/neko_log"
 	"github.com/matsuridayo/libneko/protect_server"
-	"github.com/matsuridayo/libneko/speedtest"
 
 	box "github.com/sagernet/sing-box"
+	"github.com/sagernet/sing-box/adapter"
 	"github.com/sagernet/sing-box/common/conntrack"
+	"github.com/sagernet/sing-box/common/urltest"
 	"github.com/sagernet/sing-box/constant"
 	"github.com/sagernet/sing-box/option"
-	"github.com/sagernet/sing-box/outbound"
+	tun "github.com/sagernet/sing-tun"
+	N "github.com/sagernet/sing/common/network"
+	"github.com/sagernet/sing/common/x/list"
+	"github.com/sagernet/sing/service"
 	"github.com/sagernet/sing/service/pause"
 )
 
@@ -58,20 +62,21 @@ func ResetAllConnections(system bool) {
 
 type BoxInstance struct {
 	*box.Box
-	cancel context.CancelFunc
-	state  int
-
-	v2api        *StubV2rayServer
-	selector     *outbound.Selector
+	cancel  context.CancelFunc
+	state   int
+	options option.Options
+
+	v2api        *v2rayStatsServer
+	tracker      *connectionTracker
+	watcher      *groupWatcher
+	pool         *connectionPool
+	poolCallback *list.Element[tun.DefaultInterfaceUpdateCallback]
+	dnsLog       *dnsLog
 	pauseManager pause.Manager
 
-	ForTest bool
-}
-
-type StubV2rayServer struct{}
+	urlTestHistory *urltest.HistoryStorage
 
-func (s *StubV2rayServer) QueryStats(query string) int64 {
-	return 0
+	ForTest bool
 }
 
 func NewSingBoxInstance(config string) (b *BoxInstance, err error) {
@@ -86,8 +91,13 @@ func NewSingBoxInstance(config string) (b *BoxInstance, err error) {
 
 	// create box
 	ctx, cancel := context.WithCancel(context.Background())
-	sleepManager := pause.ManagerFromContext(ctx)
-	ctx = pause.ContextWithManager(ctx, sleepManager)
+	// shared with the box, ManagerFromContext of a new context is nil
+	ctx = pause.WithDefaultManager(ctx)
+	sleepManager := service.FromContext[pause.Manager](ctx)
+	urlTestHistory := urltest.NewHistoryStorage()
+	ctx = service.ContextWithPtr(ctx, urlTestHistory)
+	dnsLog := newDNSLog()
+	ctx = service.ContextWithPtr(ctx, dnsLog)
 	instance, err := box.New(box.Options{
 		Options:           options,
 		Context:           ctx,
@@ -99,20 +109,31 @@ func NewSingBoxInstance(config string) (b *BoxInstance, err error) {
 	}
 
 	b = &BoxInstance{
-		Box:          instance,
-		cancel:       cancel,
-		pauseManager: sleepManager,
+		Box:            instance,
+		cancel:         cancel,
+		options:        options,
+		pool:           newConnectionPool(),
+		dnsLog:         dnsLog,
+		pauseManager:   sleepManager,
+		urlTestHistory: urlTestHistory,
 	}
 
 	// Corrected: Removed SetLogWritter and GetLogPlatformFormatter as they are undefined
 	// Assuming alternative logging setup
 	log.SetOutput(neko_log.LogWriter)
 
-	// selector
-	if proxy, ok := b.Router().Outbound("proxy"); ok {
-		if selector, ok := proxy.(*outbound.Selector); ok {
-			b.selector = selector
-		}
+	// connections
+	b.tracker = newConnectionTracker(b.Router(), urlTestHistory)
+	b.Router().SetClashServer(b.tracker)
+
+	// groups
+	b.watcher = newGroupWatcher(b.Router(), urlTestHistory, sleepManager)
+
+	// pooled connections are useless after the network changes
+	if monitor := b.Router().InterfaceMonitor(); monitor != nil {
+		b.poolCallback = monitor.RegisterCallback(func(int) {
+			b.pool.Reset()
+		})
 	}
 
 	return b, nil
@@ -123,7 +144,14 @@ func (b *BoxInstance) Start() (err error) {
 
 	if b.state == 0 {
 		b.state = 1
-		return b.Box.Start()
+		err = b.Box.Start()
+		if err != nil {
+			return err
+		}
+		if !b.ForTest {
+			b.watcher.Start()
+		}
+		return nil
 	}
 	return errors.New("already started")
 }
@@ -143,21 +171,32 @@ func (b *BoxInstance) Close() (err error) {
 		goServeProtect(false)
 	}
 
-	// close box
-	b.Close()
+	// stop watching groups
+	b.watcher.Close()
+	b.urlTestHistory.Close()
+
+	// drop pooled connections
+	if b.poolCallback != nil {
+		b.Router().InterfaceMonitor().UnregisterCallback(b.poolCallback)
+	}
+	b.pool.SetEnabled(false)
+
 	// close box.Box
 	b.Box.Close()
+	b.cancel()
 
 	return nil
 }
 
 func (b *BoxInstance) Sleep() {
 	b.pauseManager.DevicePause()
+	b.pool.Pause()
 	_ = b.Box.Router().ResetNetwork()
 }
 
 func (b *BoxInstance) Wake() {
 	b.pauseManager.DeviceWake()
+	b.pool.Resume()
 }
 
 func (b *BoxInstance) SetAsMain() {
@@ -165,35 +204,65 @@ func (b *BoxInstance) SetAsMain() {
 	goServeProtect(true)
 }
 
+// SetConnectionPoolEnabled keeps a few connections of each outbound and destination
+// pre-dialed for the requests made by libcore, URL tests and HTTPClient.UseOutbound.
 func (b *BoxInstance) SetConnectionPoolEnabled(enable bool) {
-	// TODO api
+	b.pool.SetEnabled(enable)
 }
 
+// SetV2rayStats enables traffic counting for the outbounds, separated by "\n".
 func (b *BoxInstance) SetV2rayStats(outbounds string) {
-	b.v2api = &StubV2rayServer{}
+	b.v2api = newV2rayStatsServer(strings.Split(outbounds, "\n"))
+	b.Box.Router().SetV2RayServer(b.v2api)
 }
 
+// QueryStats returns the bytes moved since the last query, direct is "uplink" or "downlink".
 func (b *BoxInstance) QueryStats(tag, direct string) int64 {
+	return b.QueryStatsWithReset(tag, direct, true)
+}
+
+// QueryStatsWithReset returns the counter value, and resets it to zero if reset is set.
+func (b *BoxInstance) QueryStatsWithReset(tag, direct string, reset bool) int64 {
 	if b.v2api == nil {
 		return 0
 	}
-	return b.v2api.QueryStats(fmt.Sprintf("outbound>>>%s>>>traffic>>>%s", tag, direct))
+	return b.v2api.QueryStats(statsName(tag, direct), reset)
 }
 
-func (b *BoxInstance) SelectOutbound(tag string) bool {
-	if b.selector != nil {
-		return b.selector.SelectOutbound(tag)
+// outbound returns the outbound by tag, or the default outbound if tag is empty.
+func (b *BoxInstance) outbound(tag string) (adapter.Outbound, error) {
+	if tag == "" {
+		return b.Router().DefaultOutbound(N.NetworkTCP)
 	}
-	return false
+	outbound, loaded := b.Router().Outbound(tag)
+	if !loaded {
+		return nil, fmt.Errorf("outbound not found: %s", tag)
+	}
+	return outbound, nil
 }
 
-func UrlTest(i *BoxInstance, link string, timeout int32) (latency int32, err error) {
-	defer device.DeferPanicToError("box.UrlTest", func(err_ error) { err = err_ })
-	if i == nil {
-		// test current
-		return speedtest.UrlTest(nil, link, timeout, speedtest.UrlTestStandard_RTT)
+// outboundDialer is outbound with the connection pool.
+func (b *BoxInstance) outboundDialer(tag string) (N.Dialer, error) {
+	outbound, err := b.outbound(tag)
+	if err != nil {
+		return nil, err
 	}
-	return speedtest.UrlTest(nil, link, timeout, speedtest.UrlTestStandard_RTT)
+	return b.pool.Dialer(outbound), nil
+}
+
+// QueryConnections returns the connections alive at the moment of calling.
+func (b *BoxInstance) QueryConnections() ConnectionIterator {
+	return newIterator(b.tracker.Connections())
+}
+
+// CloseConnection closes one connection by its ID, returns false if it has already gone.
+func (b *BoxInstance) CloseConnection(id int64) bool {
+	return b.tracker.CloseConnection(id)
+}
+
+// SelectOutbound selects the outbound in the "proxy" selector.
+func (b *BoxInstance) SelectOutbound(tag string) bool {
+	return b.SelectOutboundIn("proxy", tag)
 }
 
 var protectCloser io.Closer
@@ -0,0 +1,333 @@
+package libcore
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+
+	C "github.com/sagernet/sing-box/constant"
+	"github.com/sagernet/sing-box/option"
+	"github.com/sagernet/sing/common"
+	"github.com/sagernet/sing/common/json"
+)
+
+type ConfigIssue struct {
+	Path    string
+	Message string
+}
+
+type ConfigIssueIterator interface {
+	Len() int32
+	HasNext() bool
+	Next() *ConfigIssue
+}
+
+type ConfigReport struct {
+	errors   []*ConfigIssue
+	warnings []*ConfigIssue
+}
+
+func (r *ConfigReport) Valid() bool {
+	return len(r.errors) == 0
+}
+
+func (r *ConfigReport) GetErrors() ConfigIssueIterator {
+	return newIterator(r.errors)
+}
+
+func (r *ConfigReport) GetWarnings() ConfigIssueIterator {
+	return newIterator(r.warnings)
+}
+
+func (r *ConfigReport) error(path string, message ...any) {
+	r.errors = append(r.errors, &ConfigIssue{Path: path, Message: fmt.Sprint(message...)})
+}
+
+func (r *ConfigReport) warn(path string, message ...any) {
+	r.warnings = append(r.warnings, &ConfigIssue{Path: path, Message: fmt.Sprint(message...)})
+}
+
+// CheckConfig decodes the config without starting anything, and reports
+// decode errors and suspicious options by JSON path.
+func CheckConfig(config string) *ConfigReport {
+	report := new(ConfigReport)
+	var options option.Options
+	err := options.UnmarshalJSON([]byte(config))
+	if err != nil {
+		locateDecodeError(report, []byte(config), err)
+		return report
+	}
+	lintOptions(report, options)
+	return report
+}
+
+func decodeStrict(content []byte, value any) error {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(value)
+}
+
+// locateDecodeError decodes the config piece by piece to find where err comes from.
+func locateDecodeError(report *ConfigReport, content []byte, err error) {
+	var sections map[string]json.RawMessage
+	if json.Unmarshal(content, &sections) != nil {
+		report.error("$", err)
+		return
+	}
+	located := len(report.errors)
+	for _, key := range sortedKeys(sections) {
+		section := sections[key]
+		path := "$." + key
+		switch key {
+		case "$schema":
+		case "log":
+			locateSection[option.LogOptions](report, path, section)
+		case "ntp":
+			locateSection[option.NTPOptions](report, path, section)
+		case "experimental":
+			locateSection[option.ExperimentalOptions](report, path, section)
+		case "inbounds":
+			locateList[option.Inbound](report, path, section)
+		case "outbounds":
+			locateList[option.Outbound](report, path, section)
+		case "dns":
+			if !locateSection[option.DNSOptions](report, path, section) {
+				locateFields(report, path, section, map[string]func(string, json.RawMessage){
+					"servers": func(path string, content json.RawMessage) {
+						locateList[option.DNSServerOptions](report, path, content)
+					},
+					"rules": func(path string, content json.RawMessage) {
+						locateList[option.DNSRule](report, path, content)
+					},
+				})
+			}
+		case "route":
+			if !locateSection[option.RouteOptions](report, path, section) {
+				locateFields(report, path, section, map[string]func(string, json.RawMessage){
+					"rules": func(path string, content json.RawMessage) {
+						locateList[option.Rule](report, path, content)
+					},
+					"rule_set": func(path string, content json.RawMessage) {
+						locateList[option.RuleSet](report, path, content)
+					},
+				})
+			}
+		default:
+			report.error(path, "unknown field")
+		}
+	}
+	if len(report.errors) == located {
+		report.error("$", err)
+	}
+}
+
+// locateSection reports the decode error of the section, returns true if it decodes.
+func locateSection[T any](report *ConfigReport, path string, content json.RawMessage) bool {
+	var value T
+	err := decodeStrict(content, &value)
+	if err != nil {
+		report.error(path, err)
+		return false
+	}
+	return true
+}
+
+func locateList[T any](report *ConfigReport, path string, content json.RawMessage) {
+	var items []json.RawMessage
+	err := json.Unmarshal(content, &items)
+	if err != nil {
+		report.error(path, err)
+		return
+	}
+	for i, item := range items {
+		locateSection[T](report, fmt.Sprint(path, "[", i, "]"), item)
+	}
+}
+
+// locateFields narrows the error already reported for path down to the listed fields.
+func locateFields(report *ConfigReport, path string, content json.RawMessage, fields map[string]func(string, json.RawMessage)) {
+	var values map[string]json.RawMessage
+	if json.Unmarshal(content, &values) != nil {
+		return
+	}
+	located := len(report.errors)
+	for _, key := range sortedKeys(values) {
+		if locate, loaded := fields[key]; loaded {
+			locate(path+"."+key, values[key])
+		}
+	}
+	if len(report.errors) > located {
+		// drop the report of the whole section
+		for i, issue := range report.errors[:located] {
+			if issue.Path == path {
+				report.errors = append(report.errors[:i], report.errors[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+func sortedKeys(values map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func lintOptions(report *ConfigReport, options option.Options) {
+	outboundTags := make(map[string]bool)
+	inboundTags := make(map[string]bool)
+	for i, inbound := range options.Inbounds {
+		path := fmt.Sprint("$.inbounds[", i, "]")
+		if inbound.Tag != "" {
+			if inboundTags[inbound.Tag] {
+				report.warn(path+".tag", "duplicate inbound tag: ", inbound.Tag)
+			}
+			inboundTags[inbound.Tag] = true
+		}
+		if inbound.Type == C.TypeTun {
+			lintTun(report, path, inbound.TunOptions)
+		}
+	}
+	for i, outbound := range options.Outbounds {
+		tag := outbound.Tag
+		if tag == "" {
+			tag = fmt.Sprint(i)
+		}
+		if outboundTags[tag] {
+			report.warn(fmt.Sprint("$.outbounds[", i, "].tag"), "duplicate outbound tag: ", tag)
+		}
+		outboundTags[tag] = true
+	}
+	for i, outbound := range options.Outbounds {
+		var members []string
+		switch outbound.Type {
+		case C.TypeSelector:
+			members = outbound.SelectorOptions.Outbounds
+		case C.TypeURLTest:
+			members = outbound.URLTestOptions.Outbounds
+		}
+		for j, member := range members {
+			if !outboundTags[member] {
+				report.warn(fmt.Sprint("$.outbounds[", i, "].outbounds[", j, "]"), "unknown outbound: ", member)
+			}
+		}
+	}
+	if options.DNS != nil {
+		lintDNS(report, *options.DNS, outboundTags)
+	}
+	if options.Route != nil {
+		lintRoute(report, *options.Route, outboundTags)
+	}
+}
+
+func lintTun(report *ConfigReport, path string, options option.TunInboundOptions) {
+	// see translateUIDOptions
+	currentUser := os.Getuid() / androidUserRange
+	if len(options.IncludeAndroidUser) > 0 && !common.Contains(options.IncludeAndroidUser, currentUser) {
+		report.warn(path+".include_android_user", "only apps of the current user ", currentUser, " can be routed")
+	}
+	include := len(options.IncludeUID) > 0 || len(options.IncludeUIDRange) > 0 || len(options.IncludePackage) > 0
+	exclude := len(options.ExcludeUID) > 0 || len(options.ExcludeUIDRange) > 0 || len(options.ExcludePackage) > 0
+	if include && exclude {
+		report.warn(path, "included and excluded apps can not be used together on Android")
+	}
+}
+
+func lintDNS(report *ConfigReport, options option.DNSOptions, outboundTags map[string]bool) {
+	serverTags := make(map[string]bool)
+	for i, server := range options.Servers {
+		if server.Tag == "" {
+			continue
+		}
+		if serverTags[server.Tag] {
+			report.warn(fmt.Sprint("$.dns.servers[", i, "].tag"), "duplicate dns server tag: ", server.Tag)
+		}
+		serverTags[server.Tag] = true
+	}
+	for i, server := range options.Servers {
+		path := fmt.Sprint("$.dns.servers[", i, "]")
+		if server.Detour != "" && !outboundTags[server.Detour] {
+			report.warn(path+".detour", "unknown outbound: ", server.Detour)
+		}
+		if server.AddressResolver != "" && !serverTags[server.AddressResolver] {
+			report.warn(path+".address_resolver", "unknown dns server: ", server.AddressResolver)
+		}
+	}
+	var seen []string
+	for i, rule := range options.Rules {
+		path := fmt.Sprint("$.dns.rules[", i, "]")
+		var server string
+		switch rule.Type {
+		case C.RuleTypeLogical:
+			server = rule.LogicalOptions.Server
+			rule.LogicalOptions.Server = ""
+		default:
+			server = rule.DefaultOptions.Server
+			rule.DefaultOptions.Server = ""
+		}
+		if server != "" && !serverTags[server] {
+			report.warn(path+".server", "unknown dns server: ", server)
+		}
+		seen = lintUnreachable(report, path, rule, seen)
+	}
+	if options.Final != "" && !serverTags[options.Final] {
+		report.warn("$.dns.final", "unknown dns server: ", options.Final)
+	}
+}
+
+func lintRoute(report *ConfigReport, options option.RouteOptions, outboundTags map[string]bool) {
+	ruleSetTags := make(map[string]bool)
+	for i, ruleSet := range options.RuleSet {
+		if ruleSetTags[ruleSet.Tag] {
+			report.warn(fmt.Sprint("$.route.rule_set[", i, "].tag"), "duplicate rule-set tag: ", ruleSet.Tag)
+		}
+		ruleSetTags[ruleSet.Tag] = true
+	}
+	var seen []string
+	for i, rule := range options.Rules {
+		path := fmt.Sprint("$.route.rules[", i, "]")
+		var outbound string
+		switch rule.Type {
+		case C.RuleTypeLogical:
+			outbound = rule.LogicalOptions.Outbound
+			rule.LogicalOptions.Outbound = ""
+		default:
+			outbound = rule.DefaultOptions.Outbound
+			rule.DefaultOptions.Outbound = ""
+			for _, tag := range rule.DefaultOptions.RuleSet {
+				if !ruleSetTags[tag] {
+					report.warn(path+".rule_set", "unknown rule-set: ", tag)
+				}
+			}
+		}
+		if outbound == "" {
+			report.warn(path+".outbound", "missing outbound")
+		} else if !outboundTags[outbound] {
+			report.warn(path+".outbound", "unknown outbound: ", outbound)
+		}
+		seen = lintUnreachable(report, path, rule, seen)
+	}
+	if options.Final != "" && !outboundTags[options.Final] {
+		report.warn("$.route.final", "unknown outbound: ", options.Final)
+	}
+}
+
+// lintUnreachable warns about a rule with the same conditions as an earlier one,
+// the target of rule must be cleared by the caller.
+func lintUnreachable(report *ConfigReport, path string, rule any, seen []string) []string {
+	content, err := json.Marshal(rule)
+	if err != nil {
+		return seen
+	}
+	for i, conditions := range seen {
+		if conditions == string(content) {
+			report.warn(path, "unreachable, same conditions as rules[", i, "]")
+			break
+		}
+	}
+	return append(seen, string(content))
+}
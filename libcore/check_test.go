@@ -0,0 +1,91 @@
+package libcore
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckConfig(t *testing.T) {
+	testCases := []struct {
+		name     string
+		config   string
+		errors   []string
+		warnings []string
+	}{
+		{
+			name:   "valid",
+			config: `{"outbounds":[{"type":"direct","tag":"direct"}],"route":{"rules":[{"domain":"example.com","outbound":"direct"}],"final":"direct"}}`,
+		},
+		{
+			name:   "not json",
+			config: `{"outbounds":`,
+			errors: []string{"$"},
+		},
+		{
+			name:   "unknown top level field",
+			config: `{"outbound":[]}`,
+			errors: []string{"$.outbound"},
+		},
+		{
+			name:   "unknown outbound field",
+			config: `{"outbounds":[{"type":"direct","tag":"direct"},{"type":"direct","tag":"other","bogus":1}]}`,
+			errors: []string{"$.outbounds[1]"},
+		},
+		{
+			name:   "unknown route rule field",
+			config: `{"outbounds":[{"type":"direct","tag":"direct"}],"route":{"rules":[{"domain":"example.com","outbound":"direct"},{"domain":"example.org","bogus":1}]}}`,
+			errors: []string{"$.route.rules[1]"},
+		},
+		{
+			name:   "unknown dns server field",
+			config: `{"dns":{"servers":[{"tag":"local","address":"local","bogus":1}]}}`,
+			errors: []string{"$.dns.servers[0]"},
+		},
+		{
+			name:     "duplicate tags",
+			config:   `{"inbounds":[{"type":"mixed","tag":"in"},{"type":"mixed","tag":"in"}],"outbounds":[{"type":"direct","tag":"direct"},{"type":"direct","tag":"direct"}],"dns":{"servers":[{"tag":"local","address":"local"},{"tag":"local","address":"local"}]}}`,
+			warnings: []string{"$.inbounds[1].tag", "$.outbounds[1].tag", "$.dns.servers[1].tag"},
+		},
+		{
+			name:     "unknown outbound references",
+			config:   `{"outbounds":[{"type":"direct","tag":"direct"},{"type":"selector","tag":"proxy","outbounds":["direct","missing"]}],"dns":{"servers":[{"tag":"remote","address":"8.8.8.8","detour":"missing"}]},"route":{"rules":[{"domain":"example.com","outbound":"missing"},{"domain":"example.org"}],"final":"missing"}}`,
+			warnings: []string{"$.outbounds[1].outbounds[1]", "$.dns.servers[0].detour", "$.route.rules[0].outbound", "$.route.rules[1].outbound", "$.route.final"},
+		},
+		{
+			name:     "unknown dns server references",
+			config:   `{"dns":{"servers":[{"tag":"remote","address":"8.8.8.8","address_resolver":"missing"}],"rules":[{"domain":"example.com","server":"missing"}],"final":"missing"}}`,
+			warnings: []string{"$.dns.servers[0].address_resolver", "$.dns.rules[0].server", "$.dns.final"},
+		},
+		{
+			name:     "unreachable rules",
+			config:   `{"outbounds":[{"type":"direct","tag":"direct"},{"type":"block","tag":"block"}],"dns":{"servers":[{"tag":"local","address":"local"}],"rules":[{"domain":"example.com","server":"local"},{"domain":"example.com","server":"local"}]},"route":{"rules":[{"domain":"example.com","outbound":"direct"},{"domain":"example.org","outbound":"direct"},{"domain":"example.com","outbound":"block"}]}}`,
+			warnings: []string{"$.dns.rules[1]", "$.route.rules[2]"},
+		},
+		{
+			name:     "include and exclude apps",
+			config:   `{"inbounds":[{"type":"tun","include_uid":[10001],"exclude_package":["com.example"]}]}`,
+			warnings: []string{"$.inbounds[0]"},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			report := CheckConfig(testCase.config)
+			errors := configIssuePaths(report.GetErrors())
+			warnings := configIssuePaths(report.GetWarnings())
+			if !reflect.DeepEqual(errors, testCase.errors) || !reflect.DeepEqual(warnings, testCase.warnings) {
+				t.Fatalf("got errors %v, warnings %v, expected %v, %v", errors, warnings, testCase.errors, testCase.warnings)
+			}
+			if report.Valid() != (len(testCase.errors) == 0) {
+				t.Fatalf("valid: got %v with errors %v", report.Valid(), errors)
+			}
+		})
+	}
+}
+
+func configIssuePaths(issues ConfigIssueIterator) []string {
+	var paths []string
+	for issues.HasNext() {
+		paths = append(paths, issues.Next().Path)
+	}
+	return paths
+}
@@ -7,12 +7,14 @@ import (
 	"net/netip"
 	"strings"
 	"syscall"
+	"time"
 
 	dns "github.com/sagernet/sing-dns"
 	"github.com/sagernet/sing/common"
 	E "github.com/sagernet/sing/common/exceptions"
 	M "github.com/sagernet/sing/common/metadata"
 	"github.com/sagernet/sing/common/task"
+	"github.com/sagernet/sing/service"
 
 	mDNS "github.com/miekg/dns"
 )
@@ -29,7 +31,9 @@ func RegisterLocalDNSTransport(transport LocalDNSTransport) {
 	} else {
 		dns.RegisterTransport([]string{"local"}, func(options dns.TransportOptions) (dns.Transport, error) {
 			return &platformLocalDNSTransport{
-				iif: transport,
+				iif:  transport,
+				name: options.Name,
+				log:  service.PtrFromContext[dnsLog](options.Context),
 			}, nil
 		})
 	}
@@ -38,7 +42,9 @@ func RegisterLocalDNSTransport(transport LocalDNSTransport) {
 var _ dns.Transport = (*platformLocalDNSTransport)(nil)
 
 type platformLocalDNSTransport struct {
-	iif LocalDNSTransport
+	iif  LocalDNSTransport
+	name string
+	log  *dnsLog
 }
 
 func (p *platformLocalDNSTransport) Name() string {
@@ -50,6 +56,7 @@ func (p *platformLocalDNSTransport) Start() error {
 }
 
 func (p *platformLocalDNSTransport) Reset() {
+	localDNSCache.Clear()
 }
 
 func (p *platformLocalDNSTransport) Close() error {
@@ -61,6 +68,15 @@ func (p *platformLocalDNSTransport) Raw() bool {
 }
 
 func (p *platformLocalDNSTransport) Exchange(ctx context.Context, message *mDNS.Msg) (*mDNS.Msg, error) {
+	start := time.Now()
+	response, err := localDNSCache.Exchange(p.name, message, func() (*mDNS.Msg, error) {
+		return p.exchange(ctx, message)
+	})
+	p.log.logExchange(p.name, start, message, response, err)
+	return response, err
+}
+
+func (p *platformLocalDNSTransport) exchange(ctx context.Context, message *mDNS.Msg) (*mDNS.Msg, error) {
 	messageBytes, err := message.Pack()
 	if err != nil {
 		return nil, err
@@ -83,11 +99,20 @@ func (p *platformLocalDNSTransport) Exchange(ctx context.Context, message *mDNS.
 }
 
 func (p *platformLocalDNSTransport) Lookup(ctx context.Context, domain string, strategy dns.DomainStrategy) ([]netip.Addr, error) {
+	start := time.Now()
+	addresses, err := localDNSCache.Lookup(p.name, domain, strategy, func() ([]netip.Addr, error) {
+		return p.lookup(ctx, domain, strategy)
+	})
+	p.log.logLookup(p.name, start, domain, strategy, addresses, err)
+	return addresses, err
+}
+
+func (p *platformLocalDNSTransport) lookup(ctx context.Context, domain string, strategy dns.DomainStrategy) ([]netip.Addr, error) {
 	var network string
 	switch strategy {
 	case dns.DomainStrategyUseIPv4:
 		network = "ip4"
-	case dns.DomainStrategyPreferIPv6:
+	case dns.DomainStrategyUseIPv6:
 		network = "ip6"
 	default:
 		network = "ip"
@@ -96,7 +121,7 @@ func (p *platformLocalDNSTransport) Lookup(ctx context.Context, domain string, s
 		context: ctx,
 	}
 	var responseAddr []netip.Addr
-	return responseAddr, task.Run(ctx, func() error {
+	err := task.Run(ctx, func() error {
 		err := p.iif.Lookup(response, network, domain)
 		if err != nil {
 			return err
@@ -104,23 +129,35 @@ func (p *platformLocalDNSTransport) Lookup(ctx context.Context, domain string, s
 		if response.error != nil {
 			return response.error
 		}
-		switch strategy {
-		case dns.DomainStrategyUseIPv4:
-			responseAddr = common.Filter(response.addresses, func(it netip.Addr) bool {
-				return it.Is4()
-			})
-		case dns.DomainStrategyPreferIPv6:
-			responseAddr = common.Filter(response.addresses, func(it netip.Addr) bool {
-				return it.Is6()
-			})
-		default:
-			responseAddr = response.addresses
-		}
-		/*if len(responseAddr) == 0 {
-			response.error = dns.RCodeSuccess
-		}*/
-		return nil
+		responseAddr, err = filterAddresses(response.addresses, strategy)
+		return err
 	})
+	return responseAddr, err
+}
+
+// filterAddresses drops the addresses not allowed by strategy and puts the preferred family first.
+func filterAddresses(addresses []netip.Addr, strategy dns.DomainStrategy) ([]netip.Addr, error) {
+	addresses = common.Map(addresses, netip.Addr.Unmap)
+	response4 := common.Filter(addresses, netip.Addr.Is4)
+	response6 := common.Filter(addresses, netip.Addr.Is6)
+	var result []netip.Addr
+	switch strategy {
+	case dns.DomainStrategyUseIPv4:
+		result = response4
+	case dns.DomainStrategyUseIPv6:
+		result = response6
+	case dns.DomainStrategyPreferIPv4:
+		result = append(response4, response6...)
+	case dns.DomainStrategyPreferIPv6:
+		result = append(response6, response4...)
+	default:
+		result = common.Filter(addresses, netip.Addr.IsValid)
+	}
+	if len(result) == 0 {
+		// same as the router does for empty results
+		return nil, dns.RCodeNameError
+	}
+	return result, nil
 }
 
 type Func interface {
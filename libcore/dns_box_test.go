@@ -0,0 +1,86 @@
+package libcore
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+
+	dns "github.com/sagernet/sing-dns"
+)
+
+// fakeLocalDNSTransport answers lookups the way the platform does, addresses separated by "\n".
+type fakeLocalDNSTransport struct {
+	result    string
+	errorCode int32
+	network   string
+}
+
+func (t *fakeLocalDNSTransport) Raw() bool {
+	return false
+}
+
+func (t *fakeLocalDNSTransport) Lookup(ctx *ExchangeContext, network string, domain string) error {
+	t.network = network
+	if t.errorCode != 0 {
+		ctx.ErrorCode(t.errorCode)
+		return nil
+	}
+	ctx.Success(t.result)
+	return nil
+}
+
+func (t *fakeLocalDNSTransport) Exchange(ctx *ExchangeContext, message []byte) error {
+	return errors.New("not raw")
+}
+
+func TestLocalDNSTransportLookup(t *testing.T) {
+	const mixed = "1.1.1.1\n2001:db8::1\n::ffff:2.2.2.2\n2001:db8::2"
+	testCases := []struct {
+		name      string
+		result    string
+		errorCode int32
+		strategy  dns.DomainStrategy
+		network   string
+		expected  []string
+	}{
+		{"as is", mixed, 0, dns.DomainStrategyAsIS, "ip", []string{"1.1.1.1", "2001:db8::1", "2.2.2.2", "2001:db8::2"}},
+		{"prefer ipv4", mixed, 0, dns.DomainStrategyPreferIPv4, "ip", []string{"1.1.1.1", "2.2.2.2", "2001:db8::1", "2001:db8::2"}},
+		{"prefer ipv6", mixed, 0, dns.DomainStrategyPreferIPv6, "ip", []string{"2001:db8::1", "2001:db8::2", "1.1.1.1", "2.2.2.2"}},
+		{"use ipv4", mixed, 0, dns.DomainStrategyUseIPv4, "ip4", []string{"1.1.1.1", "2.2.2.2"}},
+		{"use ipv6", mixed, 0, dns.DomainStrategyUseIPv6, "ip6", []string{"2001:db8::1", "2001:db8::2"}},
+		{"v4-mapped only", "::ffff:3.3.3.3", 0, dns.DomainStrategyUseIPv4, "ip4", []string{"3.3.3.3"}},
+		{"v4-mapped is not ipv6", "::ffff:3.3.3.3", 0, dns.DomainStrategyUseIPv6, "ip6", nil},
+		{"prefer ipv6 without ipv6", "1.1.1.1", 0, dns.DomainStrategyPreferIPv6, "ip", []string{"1.1.1.1"}},
+		{"empty", "", 0, dns.DomainStrategyAsIS, "ip", nil},
+		{"empty lines", "\n\n", 0, dns.DomainStrategyPreferIPv4, "ip", nil},
+		{"no such name", "", int32(dns.RCodeNameError), dns.DomainStrategyAsIS, "ip", nil},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			platform := &fakeLocalDNSTransport{result: testCase.result, errorCode: testCase.errorCode}
+			transport := &platformLocalDNSTransport{iif: platform, name: "local"}
+			addresses, err := transport.lookup(context.Background(), "example.com", testCase.strategy)
+			if platform.network != testCase.network {
+				t.Errorf("network: got %s, expected %s", platform.network, testCase.network)
+			}
+			if testCase.expected == nil {
+				if !errors.Is(err, dns.RCodeNameError) {
+					t.Fatalf("got %v, %v, expected name error", addresses, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(addresses) != len(testCase.expected) {
+				t.Fatalf("got %v, expected %v", addresses, testCase.expected)
+			}
+			for i, address := range addresses {
+				if address != netip.MustParseAddr(testCase.expected[i]) {
+					t.Fatalf("got %v, expected %v", addresses, testCase.expected)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package libcore
+
+import (
+	"errors"
+	"log"
+	"net/netip"
+	"sync/atomic"
+	"time"
+
+	dns "github.com/sagernet/sing-dns"
+	"github.com/sagernet/sing/common/cache"
+
+	mDNS "github.com/miekg/dns"
+)
+
+const (
+	localDNSCacheSize = 1024
+	// Lookup of the platform returns addresses without TTL
+	localDNSLookupTTL = 60 * time.Second
+	// expired answers are served for this long if the platform resolver fails
+	localDNSStaleAge = time.Hour
+	// seconds, the TTL of stale answers, RFC 8767
+	localDNSStaleTTL = 30
+)
+
+// localDNSCache caches the answers of the platform resolver for the local DNS transports.
+// It is flushed by the transport Reset and ClearDNSCache of the platform interface.
+var localDNSCache = &dnsResponseCache{
+	entries: cache.New(
+		cache.WithSize[dnsCacheKey, *dnsCacheEntry](localDNSCacheSize),
+		cache.WithStale[dnsCacheKey, *dnsCacheEntry](true),
+	),
+}
+
+// SetLocalDNSCacheEnabled enables the response cache of the local DNS transport, disabled by default.
+func SetLocalDNSCacheEnabled(enabled bool) {
+	localDNSCache.enabled.Store(enabled)
+	if !enabled {
+		localDNSCache.Clear()
+	}
+}
+
+type dnsResponseCache struct {
+	enabled atomic.Bool
+	entries *cache.LruCache[dnsCacheKey, *dnsCacheEntry]
+}
+
+type dnsCacheKey struct {
+	transport string
+	question  mDNS.Question
+	strategy  dns.DomainStrategy
+}
+
+type dnsCacheEntry struct {
+	message   *mDNS.Msg
+	addresses []netip.Addr
+}
+
+func (c *dnsResponseCache) Clear() {
+	clearCache(c.entries)
+}
+
+func (c *dnsResponseCache) Exchange(transport string, message *mDNS.Msg, exchange func() (*mDNS.Msg, error)) (*mDNS.Msg, error) {
+	if !c.enabled.Load() || len(message.Question) != 1 {
+		return exchange()
+	}
+	key := dnsCacheKey{transport: transport, question: message.Question[0]}
+	entry, expires, loaded := c.entries.LoadWithExpire(key)
+	now := time.Now()
+	if loaded && now.Before(expires) {
+		ttl := uint32(expires.Sub(now).Seconds())
+		if ttl == 0 {
+			ttl = 1
+		}
+		return cachedResponse(entry.message, message, ttl), nil
+	}
+	response, err := exchange()
+	if err != nil {
+		if loaded && isStaleServable(err, now, expires) {
+			log.Println("[Debug] local dns: serve stale", message.Question[0].Name, err)
+			return cachedResponse(entry.message, message, localDNSStaleTTL), nil
+		}
+		return nil, err
+	}
+	if response.Rcode == mDNS.RcodeSuccess || response.Rcode == mDNS.RcodeNameError {
+		if ttl := messageTTL(response); ttl > 0 {
+			c.entries.StoreWithExpire(key, &dnsCacheEntry{message: response.Copy()}, now.Add(time.Duration(ttl)*time.Second))
+		}
+	}
+	return response, nil
+}
+
+func (c *dnsResponseCache) Lookup(transport string, domain string, strategy dns.DomainStrategy, lookup func() ([]netip.Addr, error)) ([]netip.Addr, error) {
+	if !c.enabled.Load() {
+		return lookup()
+	}
+	key := dnsCacheKey{transport: transport, question: mDNS.Question{Name: domain}, strategy: strategy}
+	entry, expires, loaded := c.entries.LoadWithExpire(key)
+	now := time.Now()
+	if loaded && now.Before(expires) {
+		return entry.addresses, nil
+	}
+	addresses, err := lookup()
+	if err != nil {
+		if loaded && isStaleServable(err, now, expires) {
+			log.Println("[Debug] local dns: serve stale", domain, err)
+			return entry.addresses, nil
+		}
+		return nil, err
+	}
+	if len(addresses) > 0 {
+		c.entries.StoreWithExpire(key, &dnsCacheEntry{addresses: addresses}, now.Add(localDNSLookupTTL))
+	}
+	return addresses, nil
+}
+
+// isStaleServable returns true if the resolver failed, not answered with an error code.
+func isStaleServable(err error, now time.Time, expires time.Time) bool {
+	var rcode dns.RCodeError
+	if errors.As(err, &rcode) {
+		return false
+	}
+	return now.Sub(expires) < localDNSStaleAge
+}
+
+// messageTTL returns the minimum TTL of the records, 0 if there is none.
+func messageTTL(message *mDNS.Msg) uint32 {
+	var ttl uint32
+	var found bool
+	for _, records := range [][]mDNS.RR{message.Answer, message.Ns, message.Extra} {
+		for _, record := range records {
+			if record.Header().Rrtype == mDNS.TypeOPT {
+				continue
+			}
+			if !found || record.Header().Ttl < ttl {
+				ttl = record.Header().Ttl
+				found = true
+			}
+		}
+	}
+	return ttl
+}
+
+func cachedResponse(cached *mDNS.Msg, request *mDNS.Msg, ttl uint32) *mDNS.Msg {
+	response := cached.Copy()
+	response.Id = request.Id
+	for _, records := range [][]mDNS.RR{response.Answer, response.Ns, response.Extra} {
+		for _, record := range records {
+			if record.Header().Rrtype == mDNS.TypeOPT {
+				continue
+			}
+			if record.Header().Ttl > ttl {
+				record.Header().Ttl = ttl
+			}
+		}
+	}
+	return response
+}
@@ -0,0 +1,202 @@
+package libcore
+
+import (
+	"errors"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	dns "github.com/sagernet/sing-dns"
+	"github.com/sagernet/sing/common"
+
+	mDNS "github.com/miekg/dns"
+)
+
+const dnsLogSize = 1024
+
+type DNSQuery struct {
+	Time      int64 // unix milliseconds
+	Transport string
+	Domain    string
+	QType     string
+	RCode     string // empty if the query failed without a response
+	Answers   string // separated by "\n"
+	Latency   int32  // milliseconds
+	Error     string
+}
+
+type DNSQueryIterator interface {
+	Len() int32
+	HasNext() bool
+	Next() *DNSQuery
+}
+
+type DNSTransportStats struct {
+	Transport      string
+	Queries        int64
+	Failures       int64
+	AverageLatency int32 // milliseconds
+}
+
+type DNSTransportStatsIterator interface {
+	Len() int32
+	HasNext() bool
+	Next() *DNSTransportStats
+}
+
+// dnsLog keeps the last queries of the local DNS transports in a ring buffer.
+// It is shared with the transports through the box context.
+type dnsLog struct {
+	access  sync.Mutex
+	queries []*DNSQuery
+	next    int
+	stats   map[string]*dnsTransportCounter
+}
+
+type dnsTransportCounter struct {
+	queries  int64
+	failures int64
+	latency  time.Duration
+}
+
+func newDNSLog() *dnsLog {
+	return &dnsLog{
+		queries: make([]*DNSQuery, 0, dnsLogSize),
+		stats:   make(map[string]*dnsTransportCounter),
+	}
+}
+
+func (l *dnsLog) add(query *DNSQuery, failed bool, latency time.Duration) {
+	l.access.Lock()
+	defer l.access.Unlock()
+	if len(l.queries) < dnsLogSize {
+		l.queries = append(l.queries, query)
+	} else {
+		l.queries[l.next] = query
+	}
+	l.next = (l.next + 1) % dnsLogSize
+	counter := l.stats[query.Transport]
+	if counter == nil {
+		counter = new(dnsTransportCounter)
+		l.stats[query.Transport] = counter
+	}
+	counter.queries++
+	if failed {
+		counter.failures++
+	}
+	counter.latency += latency
+}
+
+func (l *dnsLog) logExchange(transport string, start time.Time, message *mDNS.Msg, response *mDNS.Msg, err error) {
+	if l == nil || len(message.Question) == 0 {
+		return
+	}
+	question := message.Question[0]
+	query := &DNSQuery{
+		Domain: strings.TrimSuffix(question.Name, "."),
+		QType:  mDNS.TypeToString[question.Qtype],
+	}
+	if response != nil {
+		query.RCode = mDNS.RcodeToString[response.Rcode]
+		query.Answers = dnsAnswers(response)
+	}
+	l.log(query, transport, start, err, response == nil || response.Rcode != mDNS.RcodeSuccess)
+}
+
+// dnsAnswers returns the answer records without the header, separated by "\n".
+func dnsAnswers(response *mDNS.Msg) string {
+	return strings.Join(common.Map(response.Answer, func(it mDNS.RR) string {
+		return strings.TrimPrefix(it.String(), it.Header().String())
+	}), "\n")
+}
+
+func (l *dnsLog) logLookup(transport string, start time.Time, domain string, strategy dns.DomainStrategy, addresses []netip.Addr, err error) {
+	if l == nil {
+		return
+	}
+	query := &DNSQuery{
+		Domain: domain,
+	}
+	switch strategy {
+	case dns.DomainStrategyUseIPv4:
+		query.QType = "A"
+	case dns.DomainStrategyUseIPv6:
+		query.QType = "AAAA"
+	default:
+		query.QType = "A,AAAA"
+	}
+	var rcode dns.RCodeError
+	if err == nil {
+		query.RCode = mDNS.RcodeToString[mDNS.RcodeSuccess]
+	} else if errors.As(err, &rcode) {
+		query.RCode = mDNS.RcodeToString[int(rcode)]
+	}
+	query.Answers = strings.Join(common.Map(addresses, netip.Addr.String), "\n")
+	l.log(query, transport, start, err, err != nil)
+}
+
+func (l *dnsLog) log(query *DNSQuery, transport string, start time.Time, err error, failed bool) {
+	latency := time.Since(start)
+	query.Time = start.UnixMilli()
+	query.Transport = transport
+	query.Latency = int32(latency.Milliseconds())
+	if err != nil {
+		query.Error = err.Error()
+	}
+	l.add(query, failed, latency)
+}
+
+// Queries returns up to limit queries, the newest first.
+func (l *dnsLog) Queries(limit int) []*DNSQuery {
+	l.access.Lock()
+	defer l.access.Unlock()
+	if limit <= 0 || limit > len(l.queries) {
+		limit = len(l.queries)
+	}
+	queries := make([]*DNSQuery, 0, limit)
+	for i := 1; i <= limit; i++ {
+		queries = append(queries, l.queries[(l.next-i+len(l.queries))%len(l.queries)])
+	}
+	return queries
+}
+
+func (l *dnsLog) Stats() []*DNSTransportStats {
+	l.access.Lock()
+	defer l.access.Unlock()
+	stats := make([]*DNSTransportStats, 0, len(l.stats))
+	for transport, counter := range l.stats {
+		stats = append(stats, &DNSTransportStats{
+			Transport:      transport,
+			Queries:        counter.queries,
+			Failures:       counter.failures,
+			AverageLatency: int32((counter.latency / time.Duration(counter.queries)).Milliseconds()),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Transport < stats[j].Transport
+	})
+	return stats
+}
+
+func (l *dnsLog) Reset() {
+	l.access.Lock()
+	defer l.access.Unlock()
+	l.queries = l.queries[:0]
+	l.next = 0
+	l.stats = make(map[string]*dnsTransportCounter)
+}
+
+// QueryDNSLog returns up to limit queries of the local DNS transports, the newest first. All if limit <= 0.
+func (b *BoxInstance) QueryDNSLog(limit int32) DNSQueryIterator {
+	return newIterator(b.dnsLog.Queries(int(limit)))
+}
+
+func (b *BoxInstance) QueryDNSStats() DNSTransportStatsIterator {
+	return newIterator(b.dnsLog.Stats())
+}
+
+func (b *BoxInstance) ResetDNSLog() {
+	b.dnsLog.Reset()
+}
@@ -0,0 +1,222 @@
+package libcore
+
+import (
+	"context"
+	"crypto/tls"
+	"net/url"
+	"strings"
+	"time"
+
+	"libcore/device"
+
+	dns "github.com/sagernet/sing-dns"
+	E "github.com/sagernet/sing/common/exceptions"
+	"github.com/sagernet/sing/common/logger"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+
+	mDNS "github.com/miekg/dns"
+)
+
+type DnsTestResult struct {
+	RCode   string
+	Answers string // separated by "\n"
+	Latency int32  // milliseconds, including the connection setup
+	// TLS details of tls, https, quic and h3 servers
+	TLSVersion     string
+	TLSCipherSuite string
+	TLSALPN        string
+	TLSServerName  string
+	TLSCertificate string // subject of the server certificate
+	TLSIssuer      string
+	TLSNotAfter    int64 // unix seconds
+	// TLSProbed is set if the details come from a separate handshake, see DnsTest
+	TLSProbed bool
+	TLSError  string
+}
+
+// DnsTest sends one query to the server directly, the server is in the format of sing-box DNS servers:
+// 8.8.8.8, tcp://8.8.8.8, tls://dns.google, https://dns.google/dns-query, quic://dns.adguard.com or h3://dns.google/dns-query.
+//
+// The query goes through the sing-dns transport of the scheme, like the ones of the box. The TLS details
+// of tls servers are taken from the connection of the query. The https, quic and h3 transports keep their
+// connections and TLS configs to themselves, so their details come from a separate handshake with the
+// same server name and protocols, and TLSProbed is set.
+func DnsTest(server string, domain string, qtype string, timeout int32) (result *DnsTestResult, err error) {
+	defer device.DeferPanicToError("DnsTest", func(err_ error) { err = err_ })
+
+	queryType, loaded := mDNS.StringToType[strings.ToUpper(qtype)]
+	if !loaded {
+		return nil, E.New("unknown query type: ", qtype)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Millisecond)
+	defer cancel()
+
+	options := dns.TransportOptions{
+		Context: ctx,
+		Logger:  logger.NOP(),
+		Name:    "dns-test",
+		Dialer:  dnsTestDialer(),
+		Address: server,
+	}
+	message := new(mDNS.Msg)
+	message.SetQuestion(mDNS.Fqdn(domain), queryType)
+	start := time.Now()
+	var response *mDNS.Msg
+	var state *tls.ConnectionState
+	if strings.HasPrefix(server, "tls://") {
+		response, state, err = dnsTestTLSExchange(ctx, options, message)
+	} else {
+		response, err = dnsTestExchange(ctx, options, message)
+	}
+	if err != nil {
+		return nil, err
+	}
+	result = &DnsTestResult{
+		RCode:   mDNS.RcodeToString[response.Rcode],
+		Answers: dnsAnswers(response),
+		Latency: int32(time.Since(start).Milliseconds()),
+	}
+
+	if state == nil {
+		state, err = dnsTestHandshake(ctx, options.Dialer, server)
+		if err != nil {
+			result.TLSError = err.Error()
+			return result, nil
+		}
+		result.TLSProbed = state != nil
+	}
+	if state != nil {
+		result.setTLSState(state)
+	}
+	return result, nil
+}
+
+func dnsTestExchange(ctx context.Context, options dns.TransportOptions, message *mDNS.Msg) (*mDNS.Msg, error) {
+	transport, err := dns.CreateTransport(options)
+	if err != nil {
+		return nil, err
+	}
+	defer transport.Close()
+	if err = transport.Start(); err != nil {
+		return nil, err
+	}
+	return transport.Exchange(ctx, message)
+}
+
+// dnsTestTLSExchange queries with the methods the sing-dns tls transport exchanges with,
+// on a connection dialed by the transport but kept here to read its state.
+func dnsTestTLSExchange(ctx context.Context, options dns.TransportOptions, message *mDNS.Msg) (*mDNS.Msg, *tls.ConnectionState, error) {
+	transport, err := dns.NewTLSTransport(options)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer transport.Close()
+	conn, err := transport.DialContext(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+	if deadline, loaded := ctx.Deadline(); loaded {
+		_ = conn.SetDeadline(deadline)
+	}
+	err = transport.WriteMessage(conn, message)
+	if err != nil {
+		return nil, nil, err
+	}
+	response, err := transport.ReadMessage(conn)
+	if err != nil {
+		return nil, nil, err
+	}
+	state := conn.(*tls.Conn).ConnectionState()
+	// not sent for IP addresses, the transport uses the host of the address
+	serverURL, _ := url.Parse(options.Address)
+	state.ServerName = M.ParseSocksaddr(serverURL.Host).AddrString()
+	return response, &state, nil
+}
+
+// dnsTestDialer dials directly, protected from the VPN if the platform is initialized.
+func dnsTestDialer() N.Dialer {
+	dialer := new(N.DefaultDialer)
+	if intfBox != nil {
+		control := boxPlatformInterfaceInstance.AutoDetectInterfaceControl()
+		dialer.Dialer.Control = control
+		dialer.ListenConfig.Control = control
+	}
+	return dialer
+}
+
+// dnsTestHandshake does the TLS handshake of https, quic and h3 servers the same way as the transport,
+// returns nil for other servers.
+func dnsTestHandshake(ctx context.Context, dialer N.Dialer, server string) (*tls.ConnectionState, error) {
+	serverURL, err := url.Parse(server)
+	if err != nil {
+		return nil, nil
+	}
+	var defaultPort uint16
+	var nextProtos []string
+	switch serverURL.Scheme {
+	case "https":
+		defaultPort = 443
+		// "dns" of the transport with the ones added by http.Transport
+		nextProtos = []string{"h2", "dns", "http/1.1"}
+	case "quic":
+		defaultPort = 853
+		nextProtos = []string{"doq"}
+	case "h3":
+		defaultPort = 443
+		nextProtos = []string{"h3"}
+	default:
+		return nil, nil
+	}
+	serverAddr := M.ParseSocksaddr(serverURL.Host)
+	if serverAddr.Port == 0 {
+		serverAddr.Port = defaultPort
+	}
+	config := &tls.Config{
+		ServerName: serverAddr.AddrString(),
+		NextProtos: nextProtos,
+	}
+	var state *tls.ConnectionState
+	switch serverURL.Scheme {
+	case "quic", "h3":
+		state, err = quicHandshake(ctx, dialer, serverAddr, config)
+	default:
+		state, err = tlsHandshake(ctx, dialer, serverAddr, config)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// not sent for IP addresses
+	state.ServerName = config.ServerName
+	return state, nil
+}
+
+func tlsHandshake(ctx context.Context, dialer N.Dialer, serverAddr M.Socksaddr, config *tls.Config) (*tls.ConnectionState, error) {
+	conn, err := dialer.DialContext(ctx, N.NetworkTCP, serverAddr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	tlsConn := tls.Client(conn, config)
+	err = tlsConn.HandshakeContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	state := tlsConn.ConnectionState()
+	return &state, nil
+}
+
+func (r *DnsTestResult) setTLSState(state *tls.ConnectionState) {
+	r.TLSVersion = tls.VersionName(state.Version)
+	r.TLSCipherSuite = tls.CipherSuiteName(state.CipherSuite)
+	r.TLSALPN = state.NegotiatedProtocol
+	r.TLSServerName = state.ServerName
+	if len(state.PeerCertificates) > 0 {
+		certificate := state.PeerCertificates[0]
+		r.TLSCertificate = certificate.Subject.String()
+		r.TLSIssuer = certificate.Issuer.String()
+		r.TLSNotAfter = certificate.NotAfter.Unix()
+	}
+}
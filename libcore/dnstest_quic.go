@@ -0,0 +1,30 @@
+//go:build with_quic
+
+package libcore
+
+import (
+	"context"
+	"crypto/tls"
+
+	"github.com/sagernet/quic-go"
+	// the quic and h3 transports, registered by sing-box/include in sing-box builds
+	_ "github.com/sagernet/sing-dns/quic"
+	"github.com/sagernet/sing/common/bufio"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+)
+
+func quicHandshake(ctx context.Context, dialer N.Dialer, serverAddr M.Socksaddr, config *tls.Config) (*tls.ConnectionState, error) {
+	conn, err := dialer.DialContext(ctx, N.NetworkUDP, serverAddr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	quicConn, err := quic.Dial(ctx, bufio.NewUnbindPacketConn(conn), conn.RemoteAddr(), config, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer quicConn.CloseWithError(0, "")
+	state := quicConn.ConnectionState().TLS
+	return &state, nil
+}
@@ -0,0 +1,23 @@
+//go:build !with_quic
+
+package libcore
+
+import (
+	"context"
+	"crypto/tls"
+
+	C "github.com/sagernet/sing-box/constant"
+	dns "github.com/sagernet/sing-dns"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+)
+
+func init() {
+	dns.RegisterTransport([]string{"quic", "h3"}, func(options dns.TransportOptions) (dns.Transport, error) {
+		return nil, C.ErrQUICNotIncluded
+	})
+}
+
+func quicHandshake(ctx context.Context, dialer N.Dialer, serverAddr M.Socksaddr, config *tls.Config) (*tls.ConnectionState, error) {
+	return nil, C.ErrQUICNotIncluded
+}
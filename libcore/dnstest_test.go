@@ -0,0 +1,208 @@
+package libcore
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	mDNS "github.com/miekg/dns"
+)
+
+// dnsTestCertificate is issued to 127.0.0.1 by itself, and trusted as a system root by TestMain
+// since the sing-dns transports use the system ones.
+var dnsTestCertificate tls.Certificate
+
+func TestMain(m *testing.M) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "dns test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+	dnsTestCertificate = tls.Certificate{Certificate: [][]byte{certificate}, PrivateKey: key}
+	caFile, err := os.CreateTemp("", "dns-test-ca-*.pem")
+	if err != nil {
+		panic(err)
+	}
+	pem.Encode(caFile, &pem.Block{Type: "CERTIFICATE", Bytes: certificate})
+	caFile.Close()
+	// read once, on the first verification
+	os.Setenv("SSL_CERT_FILE", caFile.Name())
+	code := m.Run()
+	os.Remove(caFile.Name())
+	os.Exit(code)
+}
+
+type dnsTestServers struct {
+	udp   string
+	tcp   string
+	tls   string
+	https string
+}
+
+// startDnsTestServers starts plain, tls and https stand-ins answering A queries with 192.0.2.1.
+func startDnsTestServers(t *testing.T) *dnsTestServers {
+	handler := mDNS.HandlerFunc(func(w mDNS.ResponseWriter, request *mDNS.Msg) {
+		response := new(mDNS.Msg)
+		response.SetReply(request)
+		if request.Question[0].Qtype == mDNS.TypeA {
+			response.Answer = append(response.Answer, &mDNS.A{
+				Hdr: mDNS.RR_Header{Name: request.Question[0].Name, Rrtype: mDNS.TypeA, Class: mDNS.ClassINET, Ttl: 60},
+				A:   net.IPv4(192, 0, 2, 1),
+			})
+		}
+		w.WriteMsg(response)
+	})
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{dnsTestCertificate},
+	}
+
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsListener, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, server := range []*mDNS.Server{
+		{PacketConn: packetConn, Handler: handler},
+		{Listener: listener, Handler: handler},
+		{Listener: tlsListener, Net: "tcp-tls", Handler: handler},
+	} {
+		started := make(chan struct{})
+		server.NotifyStartedFunc = func() { close(started) }
+		go server.ActivateAndServe()
+		<-started
+		t.Cleanup(func() { server.Shutdown() })
+	}
+
+	httpsServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, _ := io.ReadAll(r.Body)
+		request := new(mDNS.Msg)
+		if r.Method != http.MethodPost || request.Unpack(content) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		writer := &dnsTestResponseWriter{}
+		handler.ServeDNS(writer, request)
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(writer.content)
+	}))
+	httpsServer.EnableHTTP2 = true
+	httpsServer.TLS = tlsConfig
+	httpsServer.StartTLS()
+	t.Cleanup(httpsServer.Close)
+
+	return &dnsTestServers{
+		udp:   packetConn.LocalAddr().String(),
+		tcp:   "tcp://" + listener.Addr().String(),
+		tls:   "tls://" + tlsListener.Addr().String(),
+		https: httpsServer.URL + "/dns-query",
+	}
+}
+
+type dnsTestResponseWriter struct {
+	mDNS.ResponseWriter
+	content []byte
+}
+
+func (w *dnsTestResponseWriter) WriteMsg(message *mDNS.Msg) (err error) {
+	w.content, err = message.Pack()
+	return
+}
+
+func TestDnsTest(t *testing.T) {
+	servers := startDnsTestServers(t)
+	testCases := []struct {
+		name    string
+		server  string
+		qtype   string
+		probed  bool
+		alpn    string
+		answers string
+	}{
+		{"udp", servers.udp, "A", false, "", "192.0.2.1"},
+		{"udp url", "udp://" + servers.udp, "a", false, "", "192.0.2.1"},
+		{"tcp", servers.tcp, "A", false, "", "192.0.2.1"},
+		{"no answer", servers.tcp, "AAAA", false, "", ""},
+		{"tls", servers.tls, "A", false, "", "192.0.2.1"},
+		{"https", servers.https, "A", true, "h2", "192.0.2.1"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			result, err := DnsTest(testCase.server, "example.com", testCase.qtype, 3000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.RCode != "NOERROR" || result.Answers != testCase.answers {
+				t.Fatalf("got %s %q, expected NOERROR %q", result.RCode, result.Answers, testCase.answers)
+			}
+			if result.TLSError != "" || result.TLSProbed != testCase.probed {
+				t.Fatalf("unexpected TLS details: %+v", result)
+			}
+			if !strings.Contains(testCase.server, "s://") {
+				if result.TLSVersion != "" {
+					t.Fatalf("TLS details of a plain server: %+v", result)
+				}
+				return
+			}
+			if result.TLSVersion != "TLS 1.3" || result.TLSALPN != testCase.alpn || result.TLSServerName != "127.0.0.1" ||
+				result.TLSCertificate != "CN=dns test" || result.TLSIssuer != "CN=dns test" || result.TLSNotAfter == 0 {
+				t.Fatalf("unexpected TLS details: %+v", result)
+			}
+		})
+	}
+}
+
+func TestDnsTestErrors(t *testing.T) {
+	servers := startDnsTestServers(t)
+	testCases := []struct {
+		name   string
+		server string
+		qtype  string
+	}{
+		{"unknown type", servers.udp, "NOPE"},
+		{"unknown scheme", "nope://" + servers.udp, "A"},
+		{"unreachable", "https://127.0.0.1:1/dns-query", "A"},
+		{"not tls", "tls://" + strings.TrimPrefix(servers.tcp, "tcp://"), "A"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			result, err := DnsTest(testCase.server, "example.com", testCase.qtype, 1000)
+			if err == nil {
+				t.Fatalf("expected error, got %+v", result)
+			}
+		})
+	}
+}
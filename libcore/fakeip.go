@@ -0,0 +1,100 @@
+package libcore
+
+import (
+	"net/netip"
+	"strings"
+
+	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing/common"
+)
+
+type FakeIPEntry struct {
+	Address string
+	Domain  string
+}
+
+type FakeIPEntryIterator interface {
+	Len() int32
+	HasNext() bool
+	Next() *FakeIPEntry
+}
+
+func (b *BoxInstance) fakeIPStore() adapter.FakeIPStore {
+	if b.Box == nil {
+		return nil
+	}
+	return b.Router().FakeIPStore()
+}
+
+// LookupFakeIP returns the domain of the fake address, empty if the address is not allocated.
+func (b *BoxInstance) LookupFakeIP(address string) string {
+	store := b.fakeIPStore()
+	if store == nil {
+		return ""
+	}
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap()
+	if !store.Contains(addr) {
+		return ""
+	}
+	domain, _ := store.Lookup(addr)
+	return domain
+}
+
+// FakeIPForDomain returns the fake addresses allocated for the domain separated by "\n",
+// it does not allocate new ones.
+func (b *BoxInstance) FakeIPForDomain(domain string) string {
+	domain = strings.TrimSuffix(domain, ".")
+	var addresses []string
+	for _, entry := range b.fakeIPEntries() {
+		if entry.Domain == domain {
+			addresses = append(addresses, entry.Address)
+		}
+	}
+	return strings.Join(addresses, "\n")
+}
+
+// DumpFakeIP returns all allocated fake addresses. The store is persisted by the cache file
+// if experimental.cache_file.store_fakeip is enabled.
+func (b *BoxInstance) DumpFakeIP() FakeIPEntryIterator {
+	return newIterator(b.fakeIPEntries())
+}
+
+func (b *BoxInstance) fakeIPEntries() []*FakeIPEntry {
+	store := b.fakeIPStore()
+	if store == nil {
+		return nil
+	}
+	options := common.PtrValueOrDefault(common.PtrValueOrDefault(b.options.DNS).FakeIP)
+	var entries []*FakeIPEntry
+	for _, prefix := range []*netip.Prefix{options.Inet4Range, options.Inet6Range} {
+		if prefix == nil || !prefix.IsValid() {
+			continue
+		}
+		entries = append(entries, fakeIPRangeEntries(store, *prefix)...)
+	}
+	return entries
+}
+
+// fakeIPRangeEntries walks the range the way the store allocates it. The store counts from the
+// address of the range as configured, not masked: it gives out base+3 first and goes up one by one,
+// base+2 is only given out after wrapping around. The allocated ones are contiguous, so the walk
+// stops at the first free address after base+2.
+func fakeIPRangeEntries(store adapter.FakeIPStore, prefix netip.Prefix) []*FakeIPEntry {
+	var entries []*FakeIPEntry
+	wrapped := prefix.Addr().Next().Next()
+	if domain, loaded := store.Lookup(wrapped); loaded {
+		entries = append(entries, &FakeIPEntry{Address: wrapped.String(), Domain: domain})
+	}
+	for addr := wrapped.Next(); prefix.Contains(addr); addr = addr.Next() {
+		domain, loaded := store.Lookup(addr)
+		if !loaded {
+			break
+		}
+		entries = append(entries, &FakeIPEntry{Address: addr.String(), Domain: domain})
+	}
+	return entries
+}
@@ -0,0 +1,61 @@
+package libcore
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"github.com/sagernet/sing-box/transport/fakeip"
+	"github.com/sagernet/sing/common/logger"
+)
+
+func TestFakeIPRangeEntries(t *testing.T) {
+	testCases := []struct {
+		name     string
+		prefix   string
+		domains  int
+		expected []string
+	}{
+		{"empty", "198.18.0.0/15", 0, nil},
+		{"masked", "198.18.0.0/15", 3, []string{"198.18.0.3", "198.18.0.4", "198.18.0.5"}},
+		{"not masked", "198.18.0.5/15", 2, []string{"198.18.0.8", "198.18.0.9"}},
+		{"ipv6", "fc00::/18", 2, []string{"fc00::3", "fc00::4"}},
+		// base+3 is the last one, the next wraps around to base+2
+		{"wrapped", "10.0.0.0/30", 2, []string{"10.0.0.2", "10.0.0.3"}},
+		{"overwritten", "10.0.0.0/30", 3, []string{"10.0.0.2", "10.0.0.3"}},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			prefix := netip.MustParsePrefix(testCase.prefix)
+			var store *fakeip.Store
+			if prefix.Addr().Is4() {
+				store = fakeip.NewStore(context.Background(), logger.NOP(), prefix, netip.Prefix{})
+			} else {
+				store = fakeip.NewStore(context.Background(), logger.NOP(), netip.Prefix{}, prefix)
+			}
+			err := store.Start()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+			for i := 0; i < testCase.domains; i++ {
+				_, err = store.Create(fmt.Sprint("domain", i, ".example.com"), prefix.Addr().Is6())
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			var addresses []string
+			for _, entry := range fakeIPRangeEntries(store, prefix) {
+				if domain, _ := store.Lookup(netip.MustParseAddr(entry.Address)); domain != entry.Domain {
+					t.Fatalf("%s: got %s, expected %s", entry.Address, entry.Domain, domain)
+				}
+				addresses = append(addresses, entry.Address)
+			}
+			if !reflect.DeepEqual(addresses, testCase.expected) {
+				t.Fatalf("got %v, expected %v", addresses, testCase.expected)
+			}
+		})
+	}
+}
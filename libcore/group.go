@@ -0,0 +1,226 @@
+package libcore
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing-box/common/urltest"
+	"github.com/sagernet/sing-box/outbound"
+	"github.com/sagernet/sing/common/x/list"
+	"github.com/sagernet/sing/service/pause"
+)
+
+const (
+	groupWatchInterval = 30 * time.Second
+	groupSettleDelay   = time.Second
+)
+
+// groupWatcher reports the selection changes of every selector and urltest group
+// through NB4AInterface.Selector_OnProxySelected. sing-box has no hook for this,
+// so groups are compared after known changes: SelectOutboundIn, the urltest results
+// and waking up. The urltest history hook fires before the group reselects, so every
+// update is checked again once no more arrive for groupSettleDelay. The interval only
+// catches the others, like the clash API, and stops while the device sleeps.
+type groupWatcher struct {
+	router   adapter.Router
+	selected map[string]string
+	update   chan struct{}
+	done     chan struct{}
+
+	pauseManager  pause.Manager
+	pauseCallback *list.Element[pause.Callback]
+	paused        atomic.Bool
+	pauseUpdate   chan struct{}
+}
+
+func newGroupWatcher(router adapter.Router, history *urltest.HistoryStorage, pauseManager pause.Manager) *groupWatcher {
+	w := &groupWatcher{
+		router:       router,
+		selected:     make(map[string]string),
+		update:       make(chan struct{}, 1),
+		done:         make(chan struct{}),
+		pauseManager: pauseManager,
+		pauseUpdate:  make(chan struct{}, 1),
+	}
+	// urltest groups reselect after storing the results, see loop
+	history.SetHook(w.update)
+	w.paused.Store(pauseManager.IsDevicePaused())
+	w.pauseCallback = pauseManager.RegisterCallback(func(event int) {
+		switch event {
+		case pause.EventDevicePaused:
+			w.paused.Store(true)
+		case pause.EventDeviceWake:
+			w.paused.Store(false)
+		default:
+			return
+		}
+		select {
+		case w.pauseUpdate <- struct{}{}:
+		default:
+		}
+	})
+	return w
+}
+
+func (w *groupWatcher) Start() {
+	go w.loop()
+}
+
+func (w *groupWatcher) Close() {
+	w.pauseManager.UnregisterCallback(w.pauseCallback)
+	close(w.done)
+}
+
+// Notify asks for a check after the selection may have been changed.
+func (w *groupWatcher) Notify() {
+	select {
+	case w.update <- struct{}{}:
+	default:
+	}
+}
+
+func (w *groupWatcher) loop() {
+	ticker := time.NewTicker(groupWatchInterval)
+	defer ticker.Stop()
+	if w.paused.Load() {
+		ticker.Stop()
+	}
+	settle := time.NewTimer(groupSettleDelay)
+	defer settle.Stop()
+	if !settle.Stop() {
+		<-settle.C
+	}
+	w.check()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-w.update:
+			if !settle.Stop() {
+				select {
+				case <-settle.C:
+				default:
+				}
+			}
+			settle.Reset(groupSettleDelay)
+		case <-settle.C:
+		case <-ticker.C:
+		case <-w.pauseUpdate:
+			if w.paused.Load() {
+				ticker.Stop()
+				continue
+			}
+			ticker.Reset(groupWatchInterval)
+		}
+		w.check()
+	}
+}
+
+func (w *groupWatcher) check() {
+	for _, outbound := range w.router.Outbounds() {
+		group, isGroup := outbound.(adapter.OutboundGroup)
+		if !isGroup {
+			continue
+		}
+		now := group.Now()
+		last, loaded := w.selected[group.Tag()]
+		w.selected[group.Tag()] = now
+		if loaded && last != now && intfNB4A != nil {
+			intfNB4A.Selector_OnProxySelected(group.Tag(), now)
+		}
+	}
+}
+
+type OutboundGroup struct {
+	Tag        string
+	Type       string
+	Selectable bool
+	Selected   string
+	items      []*OutboundGroupItem
+}
+
+func (g *OutboundGroup) GetItems() OutboundGroupItemIterator {
+	return newIterator(g.items)
+}
+
+type OutboundGroupItem struct {
+	Tag          string
+	Type         string
+	URLTestTime  int64 // unix milliseconds, 0 if never tested
+	URLTestDelay int32
+}
+
+type OutboundGroupIterator interface {
+	Len() int32
+	HasNext() bool
+	Next() *OutboundGroup
+}
+
+type OutboundGroupItemIterator interface {
+	Len() int32
+	HasNext() bool
+	Next() *OutboundGroupItem
+}
+
+// ListGroups returns every selector and urltest group with its members.
+func (b *BoxInstance) ListGroups() OutboundGroupIterator {
+	var groups []*OutboundGroup
+	for _, it := range b.Router().Outbounds() {
+		group, isGroup := it.(adapter.OutboundGroup)
+		if !isGroup {
+			continue
+		}
+		_, isSelector := group.(*outbound.Selector)
+		outboundGroup := &OutboundGroup{
+			Tag:        group.Tag(),
+			Type:       group.Type(),
+			Selectable: isSelector,
+			Selected:   group.Now(),
+		}
+		for _, itemTag := range group.All() {
+			itemOutbound, loaded := b.Router().Outbound(itemTag)
+			if !loaded {
+				continue
+			}
+			item := &OutboundGroupItem{
+				Tag:  itemTag,
+				Type: itemOutbound.Type(),
+			}
+			if history := b.urlTestHistory.LoadURLTestHistory(outbound.RealTag(itemOutbound)); history != nil {
+				item.URLTestTime = history.Time.UnixMilli()
+				item.URLTestDelay = int32(history.Delay)
+			}
+			outboundGroup.items = append(outboundGroup.items, item)
+		}
+		groups = append(groups, outboundGroup)
+	}
+	return newIterator(groups)
+}
+
+// SelectOutboundIn selects the outbound in the selector by tag, urltest groups are not selectable.
+func (b *BoxInstance) SelectOutboundIn(group string, tag string) bool {
+	it, loaded := b.Router().Outbound(group)
+	if !loaded {
+		return false
+	}
+	selector, isSelector := it.(*outbound.Selector)
+	if !isSelector || !selector.SelectOutbound(tag) {
+		return false
+	}
+	b.watcher.Notify()
+	return true
+}
+
+// GroupNow returns the selected outbound of the group, or empty if it is not a group.
+func (b *BoxInstance) GroupNow(group string) string {
+	it, loaded := b.Router().Outbound(group)
+	if !loaded {
+		return ""
+	}
+	outboundGroup, isGroup := it.(adapter.OutboundGroup)
+	if !isGroup {
+		return ""
+	}
+	return outboundGroup.Now()
+}
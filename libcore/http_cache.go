@@ -0,0 +1,144 @@
+package libcore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// UseCache keeps the responses of GET requests in dir, they are requested again with
+// If-None-Match and If-Modified-Since. A 304 returns the cached content, see HTTPResponse.IsNotModified.
+func (c *httpClient) UseCache(dir string) {
+	c.cacheDir = dir
+}
+
+// httpCache is the cached response of a request, the content is in path and the header in path+".json".
+type httpCache struct {
+	path    string
+	header  http.Header // nil if not cached
+	content []byte
+}
+
+// httpCacheHeader is stored in path+".json", it is renamed into place after the content,
+// so a content without the matching sha256 is a miss.
+type httpCacheHeader struct {
+	Header http.Header `json:"header"`
+	SHA256 string      `json:"sha256"`
+}
+
+func (r *httpRequest) loadCache() *httpCache {
+	if r.cacheDir == "" || r.request.Method != http.MethodGet || r.request.URL == nil {
+		return nil
+	}
+	// subscriptions may differ by User-Agent
+	key := sha256.Sum256([]byte(r.request.URL.String() + "\n" + r.request.Header.Get("User-Agent")))
+	cache := &httpCache{path: filepath.Join(r.cacheDir, hex.EncodeToString(key[:]))}
+	headerContent, err := os.ReadFile(cache.path + ".json")
+	if err != nil {
+		return cache
+	}
+	var header httpCacheHeader
+	if err = json.Unmarshal(headerContent, &header); err != nil || header.Header == nil {
+		return cache
+	}
+	content, err := os.ReadFile(cache.path)
+	if err != nil {
+		return cache
+	}
+	if sum := sha256.Sum256(content); hex.EncodeToString(sum[:]) != header.SHA256 {
+		return cache
+	}
+	cache.header, cache.content = header.Header, content
+	return cache
+}
+
+func (c *httpCache) setConditions(request *http.Request) {
+	if c.header == nil {
+		return
+	}
+	if etag := c.header.Get("ETag"); etag != "" {
+		request.Header.Set("If-None-Match", etag)
+	}
+	if lastModified := c.header.Get("Last-Modified"); lastModified != "" {
+		request.Header.Set("If-Modified-Since", lastModified)
+	}
+}
+
+func clearConditions(request *http.Request) {
+	request.Header.Del("If-None-Match")
+	request.Header.Del("If-Modified-Since")
+}
+
+// update returns the cached content for 304, or stores the content of 200.
+func (c *httpCache) update(response *httpResponse) error {
+	switch response.StatusCode {
+	case http.StatusNotModified:
+		if c.header == nil {
+			return nil
+		}
+		response.Body.Close()
+		// the header of 304 updates the cached one
+		header := c.header.Clone()
+		for key, values := range response.Header {
+			header[key] = values
+		}
+		response.Header = header
+		response.Body = io.NopCloser(bytes.NewReader(c.content))
+		response.ContentLength = int64(len(c.content))
+		response.notModified = true
+	case http.StatusOK:
+		if response.Header.Get("ETag") == "" && response.Header.Get("Last-Modified") == "" {
+			c.remove()
+			return nil
+		}
+		content, err := response.GetContent()
+		if err != nil {
+			return err
+		}
+		if err = c.store(content, response.Header); err != nil {
+			log.Println("[Debug] http cache:", err)
+		}
+		response.Body = io.NopCloser(bytes.NewReader(content))
+	}
+	return nil
+}
+
+// store renames the content into place before the header, see httpCacheHeader.
+func (c *httpCache) store(content []byte, header http.Header) error {
+	sum := sha256.Sum256(content)
+	headerContent, err := json.Marshal(httpCacheHeader{Header: header, SHA256: hex.EncodeToString(sum[:])})
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(c.path), 0o755)
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(c.path, content)
+	if err == nil {
+		err = writeFileAtomic(c.path+".json", headerContent)
+	}
+	if err != nil {
+		c.remove()
+	}
+	return err
+}
+
+func writeFileAtomic(path string, content []byte) error {
+	err := os.WriteFile(path+".tmp", content, 0o644)
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	return err
+}
+
+func (c *httpCache) remove() {
+	os.Remove(c.path)
+	os.Remove(c.path + ".json")
+}
@@ -0,0 +1,93 @@
+package libcore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHTTPCache(t *testing.T) {
+	var access sync.Mutex
+	content, ifNoneMatch := "v1", ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		access.Lock()
+		body := content
+		ifNoneMatch = r.Header.Get("If-None-Match")
+		access.Unlock()
+		w.Header().Set("ETag", `"`+body+`"`)
+		w.Header().Set("Subscription-Userinfo", "upload="+body)
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(body))
+	}))
+	defer server.Close()
+	cacheDir := t.TempDir()
+	client := NewHttpClient()
+	client.UseCache(cacheDir)
+
+	testCases := []struct {
+		name        string
+		content     string
+		prepare     func(t *testing.T)
+		ifNoneMatch string
+		notModified bool
+	}{
+		{"stored", "v1", nil, "", false},
+		{"not modified", "v1", nil, `"v1"`, true},
+		{"changed", "v2", nil, `"v1"`, false},
+		{"not modified again", "v2", nil, `"v2"`, true},
+		{"content without header", "v2", func(t *testing.T) {
+			// as if the store was interrupted before renaming the header
+			matches, err := filepath.Glob(filepath.Join(cacheDir, "*.json"))
+			if err != nil || len(matches) != 1 {
+				t.Fatal(matches, err)
+			}
+			err = os.WriteFile(strings.TrimSuffix(matches[0], ".json"), []byte("v3"), 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}, "", false},
+		{"stored again", "v2", nil, `"v2"`, true},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if testCase.prepare != nil {
+				testCase.prepare(t)
+			}
+			access.Lock()
+			content = testCase.content
+			access.Unlock()
+			request := client.NewRequest()
+			err := request.SetURL(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			response, err := request.Execute()
+			if err != nil {
+				t.Fatal(err)
+			}
+			access.Lock()
+			gotIfNoneMatch := ifNoneMatch
+			access.Unlock()
+			if gotIfNoneMatch != testCase.ifNoneMatch {
+				t.Fatalf("got If-None-Match %q, expected %q", gotIfNoneMatch, testCase.ifNoneMatch)
+			}
+			if response.IsNotModified() != testCase.notModified {
+				t.Fatalf("got not modified %v, expected %v", response.IsNotModified(), testCase.notModified)
+			}
+			got, err := response.GetContentString()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != testCase.content {
+				t.Fatalf("got %q, expected %q", got, testCase.content)
+			}
+			if userInfo := response.GetHeader("Subscription-Userinfo"); userInfo != "upload="+testCase.content {
+				t.Fatalf("got Subscription-Userinfo %q", userInfo)
+			}
+		})
+	}
+}
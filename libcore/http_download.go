@@ -0,0 +1,177 @@
+package libcore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const downloadProgressInterval = 200 * time.Millisecond
+
+type HTTPProgressCallback interface {
+	// OnProgress is called while downloading and once finished, total is -1 if unknown.
+	OnProgress(downloaded int64, total int64)
+}
+
+// Download writes the response into path+".part", which is renamed to path once complete and,
+// if sha256Hex is not empty, verified. An interrupted download is resumed by Range if the server
+// sent ETag or Last-Modified for it. callback can be nil.
+func (r *httpRequest) Download(path string, sha256Hex string, callback HTTPProgressCallback) error {
+	partPath, validatorPath := path+".part", path+".part.validator"
+	var offset int64
+	if r.request.Method == http.MethodGet {
+		validator, _ := os.ReadFile(validatorPath)
+		info, err := os.Stat(partPath)
+		if err == nil && len(validator) > 0 {
+			offset = info.Size()
+		}
+		if offset > 0 {
+			r.request.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+			r.request.Header.Set("If-Range", string(validator))
+			defer r.request.Header.Del("Range")
+			defer r.request.Header.Del("If-Range")
+		}
+	}
+	response, err := r.client.Do(&r.request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	switch {
+	case response.StatusCode == http.StatusOK:
+		offset = 0
+	case response.StatusCode == http.StatusPartialContent && offset > 0 && contentRangeStart(response) == offset:
+	case response.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// the file changed or the part is broken, start over
+		os.Remove(partPath)
+		os.Remove(validatorPath)
+		response.Body.Close()
+		r.request.Header.Del("Range")
+		r.request.Header.Del("If-Range")
+		return r.Download(path, sha256Hex, callback)
+	default:
+		return newHTTPStatusError(&httpResponse{Response: response})
+	}
+	return saveResponse(response, path, offset, sha256Hex, callback)
+}
+
+// contentRangeStart returns the first byte of "Content-Range: bytes first-last/length", -1 if invalid.
+func contentRangeStart(response *http.Response) int64 {
+	contentRange, loaded := strings.CutPrefix(response.Header.Get("Content-Range"), "bytes ")
+	if !loaded {
+		return -1
+	}
+	first, _, loaded := strings.Cut(contentRange, "-")
+	if !loaded {
+		return -1
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return start
+}
+
+// saveResponse appends the body to path+".part" from offset, then renames it to path.
+func saveResponse(response *http.Response, path string, offset int64, sha256Hex string, callback HTTPProgressCallback) error {
+	partPath, validatorPath := path+".part", path+".part.validator"
+	var hasher hash.Hash
+	if sha256Hex != "" {
+		hasher = sha256.New()
+	}
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if offset > 0 {
+		flag = os.O_WRONLY | os.O_APPEND
+		if hasher != nil {
+			err := hashFile(hasher, partPath)
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		validator := response.Header.Get("ETag")
+		if validator == "" {
+			validator = response.Header.Get("Last-Modified")
+		}
+		if validator != "" {
+			_ = os.WriteFile(validatorPath, []byte(validator), 0o644)
+		} else {
+			os.Remove(validatorPath)
+		}
+	}
+	file, err := os.OpenFile(partPath, flag, 0o644)
+	if err != nil {
+		return err
+	}
+	var writer io.Writer = file
+	if hasher != nil {
+		writer = io.MultiWriter(file, hasher)
+	}
+	if callback != nil {
+		total := int64(-1)
+		if response.ContentLength >= 0 {
+			total = offset + response.ContentLength
+		}
+		progress := &progressWriter{callback: callback, downloaded: offset, total: total}
+		writer = io.MultiWriter(writer, progress)
+		defer progress.report()
+	}
+	_, err = io.Copy(writer, response.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// keep the part to resume
+		return err
+	}
+	if hasher != nil {
+		if sum := hex.EncodeToString(hasher.Sum(nil)); !strings.EqualFold(sum, sha256Hex) {
+			os.Remove(partPath)
+			os.Remove(validatorPath)
+			return errors.New("sha256 mismatch: " + sum)
+		}
+	}
+	err = os.Rename(partPath, path)
+	if err != nil {
+		return err
+	}
+	os.Remove(validatorPath)
+	return nil
+}
+
+func hashFile(hasher hash.Hash, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(hasher, file)
+	return err
+}
+
+type progressWriter struct {
+	callback   HTTPProgressCallback
+	downloaded int64
+	total      int64
+	lastReport time.Time
+}
+
+func (w *progressWriter) Write(p []byte) (n int, err error) {
+	w.downloaded += int64(len(p))
+	if time.Since(w.lastReport) >= downloadProgressInterval {
+		w.report()
+	}
+	return len(p), nil
+}
+
+func (w *progressWriter) report() {
+	w.lastReport = time.Now()
+	w.callback.OnProgress(w.downloaded, w.total)
+}
@@ -0,0 +1,137 @@
+package libcore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type downloadProgress struct {
+	downloaded int64
+	total      int64
+}
+
+func (p *downloadProgress) OnProgress(downloaded int64, total int64) {
+	p.downloaded, p.total = downloaded, total
+}
+
+func TestDownload(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 10000))
+	sum := sha256.Sum256(content)
+	sumHex := hex.EncodeToString(sum[:])
+
+	var access sync.Mutex
+	var statuses []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		recorder := &statusRecorder{ResponseWriter: w, record: func(status int) {
+			access.Lock()
+			statuses = append(statuses, status)
+			access.Unlock()
+		}}
+		http.ServeContent(recorder, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		name      string
+		part      []byte // nil for no part file
+		validator string
+		sha256Hex string
+		expected  []int // statuses sent by the server
+		wantError bool
+	}{
+		{"fresh", nil, "", sumHex, []int{http.StatusOK}, false},
+		{"resumed", content[:12345], `"v1"`, sumHex, []int{http.StatusPartialContent}, false},
+		{"validator mismatch", []byte("stale"), `"v0"`, sumHex, []int{http.StatusOK}, false},
+		{"part without validator", content[:12345], "", sumHex, []int{http.StatusOK}, false},
+		{"complete part", content, `"v1"`, sumHex, []int{http.StatusRequestedRangeNotSatisfiable, http.StatusOK}, false},
+		{"checksum mismatch", nil, "", strings.Repeat("0", 64), []int{http.StatusOK}, true},
+		{"resumed checksum mismatch", []byte("01234broken"), `"v1"`, sumHex, []int{http.StatusPartialContent}, true},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "download")
+			partPath, validatorPath := path+".part", path+".part.validator"
+			if testCase.part != nil {
+				err := os.WriteFile(partPath, testCase.part, 0o644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if testCase.validator != "" {
+				err := os.WriteFile(validatorPath, []byte(testCase.validator), 0o644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			access.Lock()
+			statuses = nil
+			access.Unlock()
+
+			request := NewHttpClient().NewRequest()
+			err := request.SetURL(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			progress := &downloadProgress{}
+			err = request.Download(path, testCase.sha256Hex, progress)
+
+			access.Lock()
+			got := statuses
+			access.Unlock()
+			if !reflect.DeepEqual(got, testCase.expected) {
+				t.Fatalf("got statuses %v, expected %v", got, testCase.expected)
+			}
+			if testCase.wantError {
+				if err == nil || !strings.Contains(err.Error(), "sha256 mismatch") {
+					t.Fatalf("got %v, expected a sha256 mismatch", err)
+				}
+				for _, leftover := range []string{path, partPath, validatorPath} {
+					if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+						t.Fatalf("%s is left: %v", filepath.Base(leftover), err)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			downloaded, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(downloaded, content) {
+				t.Fatalf("got %d bytes, expected the %d bytes sent", len(downloaded), len(content))
+			}
+			for _, leftover := range []string{partPath, validatorPath} {
+				if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+					t.Fatalf("%s is left: %v", filepath.Base(leftover), err)
+				}
+			}
+			if progress.downloaded != int64(len(content)) || progress.total != int64(len(content)) {
+				t.Fatalf("got progress %d/%d, expected %d", progress.downloaded, progress.total, len(content))
+			}
+		})
+	}
+}
+
+// statusRecorder records the status before anything is sent, so the client can not finish first.
+type statusRecorder struct {
+	http.ResponseWriter
+	record func(status int)
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.record(status)
+	r.ResponseWriter.WriteHeader(status)
+}
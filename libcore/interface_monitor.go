@@ -1,48 +1,266 @@
 package libcore
 
 import (
+	"log"
 	"net/netip"
+	"sync"
 
+	"github.com/sagernet/sing-box/adapter"
 	tun "github.com/sagernet/sing-tun"
+	"github.com/sagernet/sing/common"
+	"github.com/sagernet/sing/common/control"
+	E "github.com/sagernet/sing/common/exceptions"
+	"github.com/sagernet/sing/common/logger"
 	"github.com/sagernet/sing/common/x/list"
 )
 
-// wtf
+var (
+	_ tun.DefaultInterfaceMonitor = (*interfaceMonitor)(nil)
+	_ InterfaceUpdateListener     = (*interfaceMonitor)(nil)
+	_ InterfaceUpdateListener     = (*interfaceMonitorHub)(nil)
+)
+
+// InterfaceUpdateListener is started by BoxPlatformInterface.StartDefaultInterfaceMonitor,
+// the platform calls UpdateDefaultInterface when the default network changes.
+type InterfaceUpdateListener interface {
+	// UpdateDefaultInterface with empty name or index -1 means there is no network.
+	UpdateDefaultInterface(interfaceName string, interfaceIndex int32, isExpensive bool, isConstrained bool)
+	// UpdateWIFIState re-reads BoxPlatformInterface.ReadWIFIState, the WIFI may change
+	// without changing the default interface.
+	UpdateWIFIState()
+}
 
 type interfaceMonitor struct {
+	router adapter.Router
+	logger logger.Logger
+
+	access                sync.Mutex
+	networkAddresses      []networkAddress
+	defaultInterfaceName  string
+	defaultInterfaceIndex int
+	isExpensive           bool
+	isConstrained         bool
+	callbacks             list.List[tun.DefaultInterfaceUpdateCallback]
 }
 
-func (i *interfaceMonitor) Start() error {
-	return nil
+type networkAddress struct {
+	interfaceName  string
+	interfaceIndex int
+	addresses      []netip.Prefix
+}
+
+func (m *interfaceMonitor) Start() error {
+	return defaultInterfaceMonitorHub.add(m)
 }
 
-func (i *interfaceMonitor) Close() error {
+func (m *interfaceMonitor) Close() error {
+	return defaultInterfaceMonitorHub.remove(m)
+}
+
+// defaultInterfaceMonitorHub is the only listener started on the platform, test instances
+// have monitors too, the updates are shared by the monitors of all instances.
+var defaultInterfaceMonitorHub = &interfaceMonitorHub{
+	monitors: make(map[*interfaceMonitor]struct{}),
+}
+
+type interfaceMonitorHub struct {
+	// serializes starting and closing the platform listener, which may call back synchronously
+	startAccess sync.Mutex
+	started     bool
+
+	access   sync.Mutex
+	monitors map[*interfaceMonitor]struct{}
+	// the last update, for monitors started later
+	updated        bool
+	interfaceName  string
+	interfaceIndex int32
+	isExpensive    bool
+	isConstrained  bool
+}
+
+func (h *interfaceMonitorHub) add(m *interfaceMonitor) error {
+	h.startAccess.Lock()
+	defer h.startAccess.Unlock()
+	h.access.Lock()
+	h.monitors[m] = struct{}{}
+	updated, interfaceName, interfaceIndex, isExpensive, isConstrained := h.updated, h.interfaceName, h.interfaceIndex, h.isExpensive, h.isConstrained
+	h.access.Unlock()
+	if !h.started {
+		err := intfBox.StartDefaultInterfaceMonitor(h)
+		if err != nil {
+			h.access.Lock()
+			delete(h.monitors, m)
+			h.access.Unlock()
+			return err
+		}
+		h.started = true
+	} else if updated {
+		m.UpdateDefaultInterface(interfaceName, interfaceIndex, isExpensive, isConstrained)
+	}
 	return nil
 }
 
-func (i *interfaceMonitor) DefaultInterfaceName(destination netip.Addr) string {
-	return ""
+func (h *interfaceMonitorHub) remove(m *interfaceMonitor) error {
+	h.startAccess.Lock()
+	defer h.startAccess.Unlock()
+	h.access.Lock()
+	delete(h.monitors, m)
+	last := len(h.monitors) == 0
+	if last {
+		h.updated = false
+	}
+	h.access.Unlock()
+	if !last || !h.started {
+		return nil
+	}
+	h.started = false
+	return intfBox.CloseDefaultInterfaceMonitor(h)
+}
+
+func (h *interfaceMonitorHub) snapshot() []*interfaceMonitor {
+	monitors := make([]*interfaceMonitor, 0, len(h.monitors))
+	for monitor := range h.monitors {
+		monitors = append(monitors, monitor)
+	}
+	return monitors
+}
+
+func (h *interfaceMonitorHub) UpdateDefaultInterface(interfaceName string, interfaceIndex int32, isExpensive bool, isConstrained bool) {
+	h.access.Lock()
+	h.updated = true
+	h.interfaceName = interfaceName
+	h.interfaceIndex = interfaceIndex
+	h.isExpensive = isExpensive
+	h.isConstrained = isConstrained
+	monitors := h.snapshot()
+	h.access.Unlock()
+	for _, monitor := range monitors {
+		monitor.UpdateDefaultInterface(interfaceName, interfaceIndex, isExpensive, isConstrained)
+	}
+}
+
+func (h *interfaceMonitorHub) UpdateWIFIState() {
+	h.access.Lock()
+	monitors := h.snapshot()
+	h.access.Unlock()
+	for _, monitor := range monitors {
+		monitor.UpdateWIFIState()
+	}
+}
+
+func (m *interfaceMonitor) DefaultInterfaceName(destination netip.Addr) string {
+	name, _ := m.DefaultInterface(destination)
+	return name
 }
 
-func (i *interfaceMonitor) DefaultInterfaceIndex(destination netip.Addr) int {
-	return 0
+func (m *interfaceMonitor) DefaultInterfaceIndex(destination netip.Addr) int {
+	_, index := m.DefaultInterface(destination)
+	return index
 }
 
-func (i *interfaceMonitor) DefaultInterface(destination netip.Addr) (string, int) {
-	return "", 0
+func (m *interfaceMonitor) DefaultInterface(destination netip.Addr) (string, int) {
+	m.access.Lock()
+	defer m.access.Unlock()
+	for _, address := range m.networkAddresses {
+		for _, prefix := range address.addresses {
+			if prefix.Contains(destination) {
+				return address.interfaceName, address.interfaceIndex
+			}
+		}
+	}
+	return m.defaultInterfaceName, m.defaultInterfaceIndex
 }
 
-func (i *interfaceMonitor) OverrideAndroidVPN() bool {
+func (m *interfaceMonitor) OverrideAndroidVPN() bool {
 	return false
 }
 
-func (i *interfaceMonitor) AndroidVPNEnabled() bool {
+func (m *interfaceMonitor) AndroidVPNEnabled() bool {
 	return false
 }
 
-func (i *interfaceMonitor) RegisterCallback(callback tun.DefaultInterfaceUpdateCallback) *list.Element[tun.DefaultInterfaceUpdateCallback] {
-	return nil
+func (m *interfaceMonitor) RegisterCallback(callback tun.DefaultInterfaceUpdateCallback) *list.Element[tun.DefaultInterfaceUpdateCallback] {
+	m.access.Lock()
+	defer m.access.Unlock()
+	return m.callbacks.PushBack(callback)
 }
 
-func (i *interfaceMonitor) UnregisterCallback(element *list.Element[tun.DefaultInterfaceUpdateCallback]) {
+func (m *interfaceMonitor) UnregisterCallback(element *list.Element[tun.DefaultInterfaceUpdateCallback]) {
+	m.access.Lock()
+	defer m.access.Unlock()
+	m.callbacks.Remove(element)
+}
+
+func (m *interfaceMonitor) UpdateDefaultInterface(interfaceName string, interfaceIndex32 int32, isExpensive bool, isConstrained bool) {
+	if interfaceName == "" || interfaceIndex32 == -1 {
+		log.Println("[Debug] default interface: none")
+		m.access.Lock()
+		m.defaultInterfaceName = ""
+		m.defaultInterfaceIndex = -1
+		callbacks := m.callbacks.Array()
+		m.access.Unlock()
+		for _, callback := range callbacks {
+			callback(tun.EventNoRoute)
+		}
+		return
+	}
+	err := m.updateInterfaces()
+	if err == nil && m.router != nil {
+		err = m.router.UpdateInterfaces()
+	}
+	if err != nil {
+		m.logger.Error(E.Cause(err, "update interfaces"))
+	}
+	interfaceIndex := int(interfaceIndex32)
+	m.access.Lock()
+	if m.defaultInterfaceName == interfaceName && m.defaultInterfaceIndex == interfaceIndex &&
+		m.isExpensive == isExpensive && m.isConstrained == isConstrained {
+		m.access.Unlock()
+		return
+	}
+	m.defaultInterfaceName = interfaceName
+	m.defaultInterfaceIndex = interfaceIndex
+	m.isExpensive = isExpensive
+	m.isConstrained = isConstrained
+	callbacks := m.callbacks.Array()
+	m.access.Unlock()
+	log.Println("[Debug] default interface:", interfaceName, interfaceIndex, "expensive:", isExpensive, "constrained:", isConstrained)
+	for _, callback := range callbacks {
+		callback(tun.EventInterfaceUpdate)
+	}
+}
+
+func (m *interfaceMonitor) UpdateWIFIState() {
+	if m.router == nil || !m.router.NeedWIFIState() {
+		return
+	}
+	if boxPlatformInterfaceInstance.ReadWIFIState() == m.router.WIFIState() {
+		return
+	}
+	m.access.Lock()
+	callbacks := m.callbacks.Array()
+	m.access.Unlock()
+	// the router reads the state again and resets connections, so wifi rules apply to them
+	for _, callback := range callbacks {
+		callback(tun.EventInterfaceUpdate)
+	}
+}
+
+// updateInterfaces refreshes the addresses used to pick the interface of local destinations.
+func (m *interfaceMonitor) updateInterfaces() error {
+	interfaces, err := boxPlatformInterfaceInstance.Interfaces()
+	if err != nil {
+		return err
+	}
+	addresses := common.Map(interfaces, func(it control.Interface) networkAddress {
+		return networkAddress{
+			interfaceName:  it.Name,
+			interfaceIndex: it.Index,
+			addresses:      it.Addresses,
+		}
+	})
+	m.access.Lock()
+	m.networkAddresses = addresses
+	m.access.Unlock()
+	return nil
 }
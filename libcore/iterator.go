@@ -0,0 +1,28 @@
+package libcore
+
+// gomobile can not bind slices of structs, so lists are exposed as iterators.
+
+type iterator[T any] struct {
+	values []T
+}
+
+func newIterator[T any](values []T) *iterator[T] {
+	return &iterator[T]{values}
+}
+
+func (i *iterator[T]) Len() int32 {
+	return int32(len(i.values))
+}
+
+func (i *iterator[T]) HasNext() bool {
+	return len(i.values) > 0
+}
+
+func (i *iterator[T]) Next() T {
+	var next T
+	if len(i.values) > 0 {
+		next = i.values[0]
+		i.values = i.values[1:]
+	}
+	return next
+}
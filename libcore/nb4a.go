@@ -59,9 +59,6 @@ func InitCore(process, cachePath, internalAssets, externalAssets string,
 	neko_log.SetupLog(int(maxLogSizeKb)*1024, filepath.Join(cachePath, "neko.log"))
 	boxmain.SetDisableColor(true)
 
-	// nekoutils
-	// nekoutils.Selector_OnProxySelected = intfNB4A.Selector_OnProxySelected
-
 	// Set up some component
 	go func() {
 		defer device.DeferPanicToError("InitCore-go", func(err error) { log.Println(err) })
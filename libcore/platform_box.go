@@ -3,11 +3,11 @@ package libcore
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"libcore/procfs"
 	"log"
 	"net/netip"
+	"os"
 	"strings"
 	"syscall"
 
@@ -16,25 +16,37 @@ import (
 	"github.com/sagernet/sing-box/experimental/libbox/platform"
 	"github.com/sagernet/sing-box/option"
 	tun "github.com/sagernet/sing-tun"
+	"github.com/sagernet/sing/common"
 	"github.com/sagernet/sing/common/control"
 	E "github.com/sagernet/sing/common/exceptions"
 	"github.com/sagernet/sing/common/logger"
 	N "github.com/sagernet/sing/common/network"
+	"github.com/sagernet/sing/common/ranges"
 )
 
-var boxPlatformInterfaceInstance platform.Interface = &boxPlatformInterfaceWrapper{}
+var (
+	_                            platform.Interface = (*boxPlatformInterfaceWrapper)(nil)
+	boxPlatformInterfaceInstance                    = &boxPlatformInterfaceWrapper{}
+)
 
 type boxPlatformInterfaceWrapper struct{}
 
 func (w *boxPlatformInterfaceWrapper) ReadWIFIState() adapter.WIFIState {
-	state := strings.Split(intfBox.WIFIState(), ",")
+	state := intfBox.ReadWIFIState()
+	if state == nil || !state.Connected {
+		return adapter.WIFIState{}
+	}
 	return adapter.WIFIState{
-		SSID:  state[0],
-		BSSID: state[1],
+		SSID:  state.SSID,
+		BSSID: state.BSSID,
 	}
 }
 
 func (w *boxPlatformInterfaceWrapper) Initialize(ctx context.Context, router adapter.Router) error {
+	// the monitor is created before the router is ready
+	if monitor, isMonitor := router.InterfaceMonitor().(*interfaceMonitor); isMonitor {
+		monitor.router = router
+	}
 	return nil
 }
 
@@ -52,14 +64,12 @@ func (w *boxPlatformInterfaceWrapper) AutoDetectInterfaceControl() control.Func
 }
 
 func (w *boxPlatformInterfaceWrapper) OpenTun(options *tun.Options, platformOptions option.TunPlatformOptions) (tun.Tun, error) {
-	if len(options.IncludeUID) > 0 || len(options.ExcludeUID) > 0 {
-		return nil, E.New("android: unsupported uid options")
-	}
-	if len(options.IncludeAndroidUser) > 0 {
-		return nil, E.New("android: unsupported android_user option")
+	appOptions, err := translateUIDOptions(options)
+	if err != nil {
+		return nil, err
 	}
 	a, _ := json.Marshal(options)
-	b, _ := json.Marshal(platformOptions)
+	b, _ := json.Marshal(tunPlatformOptions{platformOptions, appOptions})
 	tunFd, err := intfBox.OpenTun(string(a), string(b))
 	if err != nil {
 		return nil, fmt.Errorf("intfBox.OpenTun: %v", err)
@@ -74,6 +84,80 @@ func (w *boxPlatformInterfaceWrapper) OpenTun(options *tun.Options, platformOpti
 	return tun.New(*options)
 }
 
+// tunPlatformOptions is the tunPlatformOptionsJson of BoxPlatformInterface.OpenTun.
+type tunPlatformOptions struct {
+	option.TunPlatformOptions
+	tunAppOptions
+}
+
+// tunAppOptions are the apps to route, the VpnService only takes package names.
+type tunAppOptions struct {
+	IncludePackage []string `json:"include_package,omitempty"`
+	ExcludePackage []string `json:"exclude_package,omitempty"`
+}
+
+// androidUserRange is the number of uids of each Android user, uid = user * androidUserRange + app id.
+const androidUserRange = 100000
+
+// translateUIDOptions converts the uid options of tun into package names. Only the apps of
+// the current user can be routed by the VpnService, so other users are rejected.
+func translateUIDOptions(options *tun.Options) (appOptions tunAppOptions, err error) {
+	currentUser := os.Getuid() / androidUserRange
+	if len(options.IncludeAndroidUser) > 0 && !common.Contains(options.IncludeAndroidUser, currentUser) {
+		return appOptions, E.New("android: include_android_user does not contain the current user ", currentUser)
+	}
+	appOptions.IncludePackage = append(appOptions.IncludePackage, options.IncludePackage...)
+	appOptions.ExcludePackage = append(appOptions.ExcludePackage, options.ExcludePackage...)
+	if len(options.IncludeUID) > 0 {
+		packages := packageNamesByUIDRanges(currentUser, options.IncludeUID)
+		if len(packages) == 0 {
+			return appOptions, E.New("android: no installed app matches include_uid")
+		}
+		appOptions.IncludePackage = append(appOptions.IncludePackage, packages...)
+	}
+	appOptions.ExcludePackage = append(appOptions.ExcludePackage, packageNamesByUIDRanges(currentUser, options.ExcludeUID)...)
+	appOptions.IncludePackage = common.Uniq(appOptions.IncludePackage)
+	appOptions.ExcludePackage = common.Uniq(appOptions.ExcludePackage)
+	if len(appOptions.IncludePackage) > 0 && len(appOptions.ExcludePackage) > 0 {
+		return appOptions, E.New("android: included and excluded apps can not be used together")
+	}
+	// handled by the platform
+	options.IncludeUID = nil
+	options.ExcludeUID = nil
+	options.IncludeAndroidUser = nil
+	options.IncludePackage = nil
+	options.ExcludePackage = nil
+	return appOptions, nil
+}
+
+func packageNamesByUIDRanges(user int, uidRanges []ranges.Range[uint32]) []string {
+	// uids of other users are not visible
+	userStart := uint32(user * androidUserRange)
+	userEnd := userStart + androidUserRange - 1
+	var packages []string
+	for _, uidRange := range uidRanges {
+		start, end := uidRange.Start, uidRange.End
+		if start < userStart {
+			start = userStart
+		}
+		if end > userEnd {
+			end = userEnd
+		}
+		if start > end {
+			continue
+		}
+		// one call for the range, the platform knows the installed packages
+		packageNames, err := intfBox.PackageNamesByUidRange(int32(start), int32(end))
+		if err != nil {
+			continue
+		}
+		packages = append(packages, common.Filter(strings.Split(packageNames, "\n"), func(it string) bool {
+			return it != ""
+		})...)
+	}
+	return packages
+}
+
 func (w *boxPlatformInterfaceWrapper) CloseTun() error {
 	return nil
 }
@@ -83,15 +167,41 @@ func (w *boxPlatformInterfaceWrapper) UsePlatformDefaultInterfaceMonitor() bool
 }
 
 func (w *boxPlatformInterfaceWrapper) CreateDefaultInterfaceMonitor(l logger.Logger) tun.DefaultInterfaceMonitor {
-	return &interfaceMonitor{}
+	return &interfaceMonitor{logger: l}
 }
 
+// netlink is restricted for apps on Android, interfaces are listed by the platform
 func (w *boxPlatformInterfaceWrapper) UsePlatformInterfaceGetter() bool {
-	return false
+	return true
 }
 
 func (w *boxPlatformInterfaceWrapper) Interfaces() ([]control.Interface, error) {
-	return nil, errors.New("wtf")
+	iterator, err := intfBox.GetInterfaces()
+	if err != nil {
+		return nil, fmt.Errorf("intfBox.GetInterfaces: %v", err)
+	}
+	var interfaces []control.Interface
+	for iterator.HasNext() {
+		netInterface := iterator.Next()
+		if netInterface.Flags&InterfaceFlagUp == 0 {
+			continue
+		}
+		var addresses []netip.Prefix
+		for _, address := range strings.Split(netInterface.Addresses, "\n") {
+			prefix, err := netip.ParsePrefix(address)
+			if err != nil {
+				continue
+			}
+			addresses = append(addresses, prefix)
+		}
+		interfaces = append(interfaces, control.Interface{
+			Index:     int(netInterface.Index),
+			MTU:       int(netInterface.MTU),
+			Name:      netInterface.Name,
+			Addresses: addresses,
+		})
+	}
+	return interfaces, nil
 }
 
 func (w *boxPlatformInterfaceWrapper) IncludeAllNetworks() bool {
@@ -105,14 +215,21 @@ func (w *boxPlatformInterfaceWrapper) UnderNetworkExtension() bool {
 }
 
 func (w *boxPlatformInterfaceWrapper) ClearDNSCache() {
+	localDNSCache.Clear()
 }
 
 // process.Searcher
 
 func (w *boxPlatformInterfaceWrapper) FindProcessInfo(ctx context.Context, network string, source netip.AddrPort, destination netip.AddrPort) (*process.Info, error) {
+	return loadProcessInfo(processCacheKey{network, source, destination}, func() (*process.Info, error) {
+		return w.findProcessInfo(network, source, destination)
+	})
+}
+
+func (w *boxPlatformInterfaceWrapper) findProcessInfo(network string, source netip.AddrPort, destination netip.AddrPort) (*process.Info, error) {
 	var uid int32
 	if useProcfs {
-		uid = procfs.ResolveSocketByProcSearch(network, source, destination)
+		uid = procfs.ResolveSocket(network, source, destination)
 		if uid == -1 {
 			return nil, E.New("procfs: not found")
 		}
@@ -132,8 +249,7 @@ func (w *boxPlatformInterfaceWrapper) FindProcessInfo(ctx context.Context, netwo
 			return nil, err
 		}
 	}
-	packageName, _ := intfBox.PackageNameByUid(uid)
-	return &process.Info{UserId: uid, PackageName: packageName}, nil
+	return &process.Info{UserId: uid, PackageName: packageNameByUid(uid)}, nil
 }
 
 // io.Writer
@@ -17,5 +17,42 @@ type BoxPlatformInterface interface {
 	FindConnectionOwner(ipProtocol int32, sourceAddress string, sourcePort int32, destinationAddress string, destinationPort int32) (int32, error)
 	PackageNameByUid(uid int32) (string, error)
 	UIDByPackageName(packageName string) (int32, error)
-	WIFIState() string
+	// PackageNamesByUidRange returns the packages with uid from start to end, separated by "\n".
+	PackageNamesByUidRange(start int32, end int32) (string, error)
+	ReadWIFIState() *WIFIState
+	// only one listener is started at a time, shared by all instances
+	StartDefaultInterfaceMonitor(listener InterfaceUpdateListener) error
+	CloseDefaultInterfaceMonitor(listener InterfaceUpdateListener) error
+	GetInterfaces() (NetworkInterfaceIterator, error)
+}
+
+type WIFIState struct {
+	Connected bool
+	SSID      string // without quotes
+	BSSID     string
+	Frequency int32 // MHz
+	Security  string
+}
+
+// InterfaceFlag* are the bits of NetworkInterface.Flags, same as net.Flags.
+const (
+	InterfaceFlagUp int32 = 1 << iota
+	InterfaceFlagBroadcast
+	InterfaceFlagLoopback
+	InterfaceFlagPointToPoint
+	InterfaceFlagMulticast
+)
+
+type NetworkInterface struct {
+	Index     int32
+	MTU       int32
+	Name      string
+	Flags     int32
+	Addresses string // prefixes like 192.168.1.2/24, separated by "\n"
+}
+
+type NetworkInterfaceIterator interface {
+	Len() int32
+	HasNext() bool
+	Next() *NetworkInterface
 }
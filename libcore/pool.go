@@ -0,0 +1,217 @@
+package libcore
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sagernet/sing-box/adapter"
+	C "github.com/sagernet/sing-box/constant"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+)
+
+const (
+	connectionPoolSize        = 2
+	connectionPoolIdleTimeout = 30 * time.Second
+	connectionPoolProbeDelay  = time.Millisecond
+)
+
+// connectionPool keeps pre-dialed TCP connections of outbounds for the dials made
+// by libcore itself: URL tests and HTTPClient.UseOutbound. The proxy handshake of a
+// destination is done ahead, TLS to the destination is left to the caller.
+// Connections routed by sing-box are dialed inside the outbounds and can not be
+// pooled from here.
+type connectionPool struct {
+	access  sync.Mutex
+	enabled bool
+	paused  bool
+	// generation changes on every reset, so dials in flight are not pooled after it
+	generation uint64
+	idle       map[connectionPoolKey][]*pooledConn
+	filling    map[connectionPoolKey]bool
+}
+
+type connectionPoolKey struct {
+	outbound    string
+	destination string
+}
+
+type pooledConn struct {
+	net.Conn
+	timer *time.Timer
+}
+
+func newConnectionPool() *connectionPool {
+	return &connectionPool{
+		idle:    make(map[connectionPoolKey][]*pooledConn),
+		filling: make(map[connectionPoolKey]bool),
+	}
+}
+
+func (p *connectionPool) SetEnabled(enabled bool) {
+	p.access.Lock()
+	p.enabled = enabled
+	p.access.Unlock()
+	if !enabled {
+		p.Reset()
+	}
+}
+
+// Pause drops idle connections and stops dialing new ones until Resume.
+func (p *connectionPool) Pause() {
+	p.access.Lock()
+	p.paused = true
+	p.access.Unlock()
+	p.Reset()
+}
+
+// Resume drops the connections dialed before sleeping, and dials again on the next use.
+func (p *connectionPool) Resume() {
+	p.access.Lock()
+	p.paused = false
+	p.access.Unlock()
+	p.Reset()
+}
+
+// Reset closes all idle connections, the network or the outbounds have been changed.
+func (p *connectionPool) Reset() {
+	p.access.Lock()
+	idle := p.idle
+	p.idle = make(map[connectionPoolKey][]*pooledConn)
+	p.generation++
+	p.access.Unlock()
+	for _, conns := range idle {
+		for _, conn := range conns {
+			conn.timer.Stop()
+			conn.Close()
+		}
+	}
+}
+
+func (p *connectionPool) Dialer(outbound adapter.Outbound) N.Dialer {
+	return &pooledDialer{outbound, p}
+}
+
+// take returns an idle connection still open, closing the dead ones found before it.
+func (p *connectionPool) take(key connectionPoolKey) net.Conn {
+	for {
+		p.access.Lock()
+		conns := p.idle[key]
+		if len(conns) == 0 {
+			p.access.Unlock()
+			return nil
+		}
+		conn := conns[0]
+		p.idle[key] = conns[1:]
+		p.access.Unlock()
+		if !conn.timer.Stop() {
+			// expired and closed by the timer
+			continue
+		}
+		if !isConnAlive(conn.Conn) {
+			conn.Close()
+			continue
+		}
+		return conn.Conn
+	}
+}
+
+func (p *connectionPool) fill(outbound adapter.Outbound, key connectionPoolKey, destination M.Socksaddr) {
+	p.access.Lock()
+	if !p.enabled || p.paused || p.filling[key] {
+		p.access.Unlock()
+		return
+	}
+	p.filling[key] = true
+	p.access.Unlock()
+	defer func() {
+		p.access.Lock()
+		delete(p.filling, key)
+		p.access.Unlock()
+	}()
+	for {
+		p.access.Lock()
+		full := !p.enabled || p.paused || len(p.idle[key]) >= connectionPoolSize
+		generation := p.generation
+		p.access.Unlock()
+		if full {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), C.TCPTimeout)
+		conn, err := outbound.DialContext(ctx, N.NetworkTCP, destination)
+		cancel()
+		if err != nil {
+			return
+		}
+		if !p.put(key, conn, generation) {
+			conn.Close()
+			return
+		}
+	}
+}
+
+// put pools the connection unless the pool has been reset since generation.
+func (p *connectionPool) put(key connectionPoolKey, conn net.Conn, generation uint64) bool {
+	pooled := &pooledConn{Conn: conn}
+	p.access.Lock()
+	defer p.access.Unlock()
+	if !p.enabled || p.paused || p.generation != generation {
+		return false
+	}
+	pooled.timer = time.AfterFunc(connectionPoolIdleTimeout, func() {
+		p.remove(key, pooled)
+	})
+	p.idle[key] = append(p.idle[key], pooled)
+	return true
+}
+
+func (p *connectionPool) remove(key connectionPoolKey, pooled *pooledConn) {
+	p.access.Lock()
+	conns := p.idle[key]
+	for i, conn := range conns {
+		if conn == pooled {
+			p.idle[key] = append(conns[:i:i], conns[i+1:]...)
+			break
+		}
+	}
+	p.access.Unlock()
+	// closed even if taken, take skips connections whose timer has fired
+	pooled.Close()
+}
+
+// isConnAlive reads with a deadline just passed: an idle connection has nothing to
+// read, so anything but a timeout means it has been closed or broken by the other side.
+// Connections without deadlines are assumed alive.
+func isConnAlive(conn net.Conn) bool {
+	if conn.SetReadDeadline(time.Now().Add(connectionPoolProbeDelay)) != nil {
+		return true
+	}
+	_, err := conn.Read(make([]byte, 1))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		return false
+	}
+	return conn.SetReadDeadline(time.Time{}) == nil
+}
+
+type pooledDialer struct {
+	adapter.Outbound
+	pool *connectionPool
+}
+
+func (d *pooledDialer) DialContext(ctx context.Context, network string, destination M.Socksaddr) (net.Conn, error) {
+	if N.NetworkName(network) != N.NetworkTCP {
+		return d.Outbound.DialContext(ctx, network, destination)
+	}
+	key := connectionPoolKey{d.Tag(), destination.String()}
+	conn := d.pool.take(key)
+	// keep the next ones warm
+	go d.pool.fill(d.Outbound, key, destination)
+	if conn != nil {
+		return conn, nil
+	}
+	return d.Outbound.DialContext(ctx, network, destination)
+}
@@ -0,0 +1,145 @@
+package libcore
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sagernet/sing-box/adapter"
+	M "github.com/sagernet/sing/common/metadata"
+)
+
+// directOutbound dials the destination itself and counts the dials.
+type directOutbound struct {
+	adapter.Outbound
+	dials atomic.Int32
+}
+
+func (o *directOutbound) Tag() string {
+	return "direct"
+}
+
+func (o *directOutbound) DialContext(ctx context.Context, network string, destination M.Socksaddr) (net.Conn, error) {
+	o.dials.Add(1)
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, destination.String())
+}
+
+// startPoolTestServer accepts connections and hands them to the test.
+func startPoolTestServer(t *testing.T) (M.Socksaddr, chan net.Conn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	accepted := make(chan net.Conn, connectionPoolSize+2)
+	go func() {
+		var conns []net.Conn
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				for _, conn := range conns {
+					conn.Close()
+				}
+				return
+			}
+			conns = append(conns, conn)
+			select {
+			case accepted <- conn:
+			default:
+			}
+		}
+	}()
+	return M.SocksaddrFromNet(listener.Addr()), accepted
+}
+
+// waitPooled waits for the pool to be filled for the destination.
+func waitPooled(t *testing.T, pool *connectionPool, destination M.Socksaddr, count int) {
+	t.Helper()
+	key := connectionPoolKey{"direct", destination.String()}
+	for i := 0; i < 100; i++ {
+		pool.access.Lock()
+		pooled := len(pool.idle[key])
+		pool.access.Unlock()
+		if pooled == count {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("pool not filled to %d", count)
+}
+
+func TestConnectionPool(t *testing.T) {
+	destination, accepted := startPoolTestServer(t)
+	outbound := &directOutbound{}
+	pool := newConnectionPool()
+	pool.SetEnabled(true)
+	defer pool.Reset()
+	dialer := pool.Dialer(outbound)
+
+	conn, err := dialer.DialContext(context.Background(), "tcp", destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	waitPooled(t, pool, destination, connectionPoolSize)
+	if dials := outbound.dials.Load(); dials != 1+connectionPoolSize {
+		t.Fatalf("got %d dials, expected %d", dials, 1+connectionPoolSize)
+	}
+
+	// closed by the server while idle
+	<-accepted
+	(<-accepted).Close()
+	time.Sleep(10 * time.Millisecond)
+	conn, err = dialer.DialContext(context.Background(), "tcp", destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	server := <-accepted
+	_, err = conn.Write([]byte("ping"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = server.Read(make([]byte, 4))
+	if err != nil {
+		t.Fatal("got a dead connection from the pool: ", err)
+	}
+	waitPooled(t, pool, destination, connectionPoolSize)
+	if dials := outbound.dials.Load(); dials != 1+2*connectionPoolSize {
+		t.Fatalf("got %d dials, expected %d", dials, 1+2*connectionPoolSize)
+	}
+
+	// nothing is taken or dialed while paused
+	pool.Pause()
+	waitPooled(t, pool, destination, 0)
+	conn, err = dialer.DialContext(context.Background(), "tcp", destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	time.Sleep(50 * time.Millisecond)
+	if dials := outbound.dials.Load(); dials != 2+2*connectionPoolSize {
+		t.Fatalf("got %d dials while paused, expected %d", dials, 2+2*connectionPoolSize)
+	}
+}
+
+func TestConnectionPoolDisabled(t *testing.T) {
+	destination, _ := startPoolTestServer(t)
+	outbound := &directOutbound{}
+	dialer := newConnectionPool().Dialer(outbound)
+	for i := 0; i < 2; i++ {
+		conn, err := dialer.DialContext(context.Background(), "tcp", destination)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	}
+	time.Sleep(50 * time.Millisecond)
+	if dials := outbound.dials.Load(); dials != 2 {
+		t.Fatalf("got %d dials, expected 2", dials)
+	}
+}
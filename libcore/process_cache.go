@@ -0,0 +1,101 @@
+package libcore
+
+import (
+	"net/netip"
+	"sync/atomic"
+
+	"github.com/sagernet/sing-box/common/process"
+	"github.com/sagernet/sing/common/cache"
+)
+
+const (
+	processCacheSize = 1024
+	// seconds, ports are reused soon after the connection is closed
+	processCacheAge  = 10
+	packageCacheSize = 512
+)
+
+type processCacheKey struct {
+	network     string
+	source      netip.AddrPort
+	destination netip.AddrPort
+}
+
+// Lookups through procfs, netlink or JNI are slow and repeated for every connection of an app,
+// so found results are cached. Failures are not, the socket may be registered later.
+var (
+	processCache = cache.New(
+		cache.WithAge[processCacheKey, *process.Info](processCacheAge),
+		cache.WithSize[processCacheKey, *process.Info](processCacheSize),
+	)
+	// uid to package name, until the platform calls ClearPackageNameCache
+	packageNameCache = cache.New(
+		cache.WithSize[int32, string](packageCacheSize),
+	)
+
+	processCacheHits   atomic.Int64
+	processCacheMisses atomic.Int64
+	packageNameHits    atomic.Int64
+	packageNameMisses  atomic.Int64
+)
+
+// ClearPackageNameCache is called by the platform when apps are installed, updated or removed.
+func ClearPackageNameCache() {
+	clearCache(packageNameCache)
+	clearCache(processCache)
+}
+
+// clearCache removes all entries, LruCache.Clear stops after the first one.
+func clearCache[K comparable, V any](c *cache.LruCache[K, V]) {
+	var keys []K
+	c.Range(func(key K, _ V) {
+		keys = append(keys, key)
+	})
+	for _, key := range keys {
+		c.Delete(key)
+	}
+}
+
+type ProcessCacheStats struct {
+	ConnectionHits   int64
+	ConnectionMisses int64
+	PackageHits      int64
+	PackageMisses    int64
+}
+
+func GetProcessCacheStats() *ProcessCacheStats {
+	return &ProcessCacheStats{
+		ConnectionHits:   processCacheHits.Load(),
+		ConnectionMisses: processCacheMisses.Load(),
+		PackageHits:      packageNameHits.Load(),
+		PackageMisses:    packageNameMisses.Load(),
+	}
+}
+
+func loadProcessInfo(key processCacheKey, find func() (*process.Info, error)) (*process.Info, error) {
+	if info, loaded := processCache.Load(key); loaded {
+		processCacheHits.Add(1)
+		return info, nil
+	}
+	processCacheMisses.Add(1)
+	info, err := find()
+	if err != nil {
+		return nil, err
+	}
+	processCache.Store(key, info)
+	return info, nil
+}
+
+func packageNameByUid(uid int32) string {
+	if packageName, loaded := packageNameCache.Load(uid); loaded {
+		packageNameHits.Add(1)
+		return packageName
+	}
+	packageNameMisses.Add(1)
+	packageName, err := intfBox.PackageNameByUid(uid)
+	if err != nil {
+		return ""
+	}
+	packageNameCache.Store(uid, packageName)
+	return packageName
+}
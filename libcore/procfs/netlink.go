@@ -0,0 +1,143 @@
+package procfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	E "github.com/sagernet/sing/common/exceptions"
+	N "github.com/sagernet/sing/common/network"
+)
+
+const (
+	sizeOfSocketDiagRequest = syscall.SizeofNlMsghdr + 8 + 48
+	sizeOfSocketDiagMessage = 72
+	socketDiagByFamily      = 20
+	netlinkTimeout          = 100 * time.Millisecond
+)
+
+// netlinkRefused is set once the system denies NETLINK_SOCK_DIAG, like the SELinux policy of newer Android.
+var netlinkRefused atomic.Bool
+
+// resolveSocketByNetlink is replaced in tests to simulate the denial.
+var resolveSocketByNetlink = ResolveSocketByNetlink
+
+// ResolveSocket returns the uid of the socket, by netlink or by procfs if netlink is refused.
+// -1 if not found.
+func ResolveSocket(network string, source, destination netip.AddrPort) int32 {
+	if !netlinkRefused.Load() {
+		uid, _, err := resolveSocketByNetlink(network, source, destination)
+		if err == nil {
+			return uid
+		}
+		if !isRefused(err) {
+			return -1
+		}
+		netlinkRefused.Store(true)
+	}
+	return ResolveSocketByProcSearch(network, source, destination)
+}
+
+// ResolveSocketByNetlink looks up the socket by the exact 4-tuple with inet_diag,
+// returns the uid and inode of it.
+func ResolveSocketByNetlink(network string, source, destination netip.AddrPort) (uid int32, inode uint32, err error) {
+	var protocol uint8
+	switch network {
+	case N.NetworkTCP:
+		protocol = syscall.IPPROTO_TCP
+	case N.NetworkUDP:
+		protocol = syscall.IPPROTO_UDP
+		// src and dst are swapped by the kernel for udp
+		source, destination = destination, source
+	default:
+		return -1, 0, os.ErrInvalid
+	}
+	family := uint8(syscall.AF_INET6)
+	if source.Addr().Unmap().Is4() && destination.Addr().Unmap().Is4() {
+		family = syscall.AF_INET
+	}
+
+	socket, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, syscall.NETLINK_INET_DIAG)
+	if err != nil {
+		return -1, 0, E.Cause(err, "open netlink")
+	}
+	defer syscall.Close(socket)
+
+	timeout := syscall.NsecToTimeval(netlinkTimeout.Nanoseconds())
+	_ = syscall.SetsockoptTimeval(socket, syscall.SOL_SOCKET, syscall.SO_SNDTIMEO, &timeout)
+	_ = syscall.SetsockoptTimeval(socket, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &timeout)
+
+	err = syscall.Sendto(socket, packSocketDiagRequest(family, protocol, source, destination), 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK})
+	if err != nil {
+		return -1, 0, E.Cause(err, "write netlink")
+	}
+
+	buffer := make([]byte, os.Getpagesize())
+	n, _, err := syscall.Recvfrom(socket, buffer, 0)
+	if err != nil {
+		return -1, 0, E.Cause(err, "read netlink")
+	}
+	messages, err := syscall.ParseNetlinkMessage(buffer[:n])
+	if err != nil {
+		return -1, 0, E.Cause(err, "parse netlink")
+	}
+	for _, message := range messages {
+		switch message.Header.Type {
+		case syscall.NLMSG_ERROR:
+			if len(message.Data) >= 4 {
+				errno := -int32(nativeEndian.Uint32(message.Data))
+				if errno != 0 {
+					return -1, 0, E.Cause(syscall.Errno(errno), "netlink")
+				}
+			}
+			return -1, 0, E.New("netlink: bad error message")
+		case socketDiagByFamily:
+			if len(message.Data) < sizeOfSocketDiagMessage {
+				return -1, 0, E.New("netlink: short message")
+			}
+			uid = int32(nativeEndian.Uint32(message.Data[64:68]))
+			inode = nativeEndian.Uint32(message.Data[68:72])
+			return uid, inode, nil
+		}
+	}
+	return -1, 0, E.New("netlink: socket not found")
+}
+
+// packSocketDiagRequest builds nlmsghdr + inet_diag_req_v2 for an exact lookup.
+func packSocketDiagRequest(family, protocol uint8, source, destination netip.AddrPort) []byte {
+	request := make([]byte, sizeOfSocketDiagRequest)
+
+	nativeEndian.PutUint32(request[0:4], sizeOfSocketDiagRequest)
+	nativeEndian.PutUint16(request[4:6], socketDiagByFamily)
+	nativeEndian.PutUint16(request[6:8], syscall.NLM_F_REQUEST)
+
+	request[16] = family
+	request[17] = protocol
+	nativeEndian.PutUint32(request[20:24], 0xFFFFFFFF) // all states
+
+	// inet_diag_sockid
+	binary.BigEndian.PutUint16(request[24:26], source.Port())
+	binary.BigEndian.PutUint16(request[26:28], destination.Port())
+	copy(request[28:44], diagAddress(family, source.Addr()))
+	copy(request[44:60], diagAddress(family, destination.Addr()))
+	nativeEndian.PutUint64(request[64:72], 0xFFFFFFFFFFFFFFFF) // INET_DIAG_NOCOOKIE
+
+	return request
+}
+
+func diagAddress(family uint8, addr netip.Addr) []byte {
+	if family == syscall.AF_INET {
+		return addr.Unmap().AsSlice()
+	}
+	address := addr.As16()
+	return address[:]
+}
+
+func isRefused(err error) bool {
+	return errors.Is(err, syscall.EACCES) || errors.Is(err, syscall.EPERM) ||
+		errors.Is(err, syscall.EPROTONOSUPPORT) || errors.Is(err, syscall.EAFNOSUPPORT)
+}
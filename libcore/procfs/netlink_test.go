@@ -0,0 +1,167 @@
+package procfs
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"syscall"
+	"testing"
+
+	E "github.com/sagernet/sing/common/exceptions"
+	N "github.com/sagernet/sing/common/network"
+)
+
+func TestResolveSocketByNetlinkTCP(t *testing.T) {
+	for _, address := range []string{"127.0.0.1:0", "[::1]:0"} {
+		listener := listen(t, "tcp", address)
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		source, destination := addrPorts(conn)
+		expectSocket(t, N.NetworkTCP, source, destination, conn)
+
+		_, _, err = ResolveSocketByNetlink(N.NetworkTCP, source, netip.AddrPortFrom(destination.Addr(), destination.Port()+1))
+		if err == nil {
+			t.Fatal("found a socket by a wrong destination")
+		}
+	}
+}
+
+func TestResolveSocketByNetlinkUDP(t *testing.T) {
+	for _, address := range []string{"127.0.0.1:0", "[::1]:0"} {
+		server, err := net.ListenPacket("udp", address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer server.Close()
+		conn, err := net.Dial("udp", server.LocalAddr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		// without swapping, the lookup would find the server receiving from the source
+		source, destination := addrPorts(conn)
+		expectSocket(t, N.NetworkUDP, source, destination, conn)
+
+		// unconnected sockets match any destination
+		unconnected, err := net.ListenPacket("udp", address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer unconnected.Close()
+		expectSocket(t, N.NetworkUDP, netip.MustParseAddrPort(unconnected.LocalAddr().String()), destination, unconnected)
+	}
+}
+
+func TestResolveSocketByNetlinkDualStack(t *testing.T) {
+	listener := listen(t, "tcp", "[::]:0")
+	port := netip.MustParseAddrPort(listener.Addr().String()).Port()
+	conn, err := net.Dial("tcp4", netip.AddrPortFrom(netip.AddrFrom4([4]byte{127, 0, 0, 1}), port).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	accepted, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer accepted.Close()
+
+	// the accepted socket is AF_INET6 with v4-mapped addresses
+	source, destination := addrPorts(accepted)
+	expectSocket(t, N.NetworkTCP, source, destination, accepted)
+
+	// v4-mapped addresses of an AF_INET socket
+	source, destination = addrPorts(conn)
+	mapped := func(addrPort netip.AddrPort) netip.AddrPort {
+		return netip.AddrPortFrom(netip.AddrFrom16(addrPort.Addr().As16()), addrPort.Port())
+	}
+	expectSocket(t, N.NetworkTCP, mapped(source), mapped(destination), conn)
+}
+
+func TestResolveSocketFallback(t *testing.T) {
+	listener := listen(t, "tcp", "127.0.0.1:0")
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	source, destination := addrPorts(conn)
+
+	var netlinkErr error
+	var netlinkCalls int
+	resolveSocketByNetlink = func(network string, source, destination netip.AddrPort) (int32, uint32, error) {
+		netlinkCalls++
+		return -1, 0, netlinkErr
+	}
+	defer func() {
+		resolveSocketByNetlink = ResolveSocketByNetlink
+		netlinkRefused.Store(false)
+	}()
+
+	// not found is not a reason to give up netlink
+	netlinkErr = E.New("netlink: socket not found")
+	if uid := ResolveSocket(N.NetworkTCP, source, destination); uid != -1 {
+		t.Fatalf("got uid %d, expected -1", uid)
+	}
+	if netlinkRefused.Load() {
+		t.Fatal("netlink refused by a missing socket")
+	}
+
+	netlinkErr = E.Cause(syscall.EACCES, "open netlink")
+	for i := 0; i < 2; i++ {
+		if uid := ResolveSocket(N.NetworkTCP, source, destination); uid != int32(os.Getuid()) {
+			t.Fatalf("got uid %d from procfs, expected %d", uid, os.Getuid())
+		}
+	}
+	if !netlinkRefused.Load() || netlinkCalls != 2 {
+		t.Fatalf("netlink called %d times after refused", netlinkCalls-1)
+	}
+}
+
+func listen(t *testing.T, network, address string) net.Listener {
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	return listener
+}
+
+func addrPorts(conn net.Conn) (source, destination netip.AddrPort) {
+	return netip.MustParseAddrPort(conn.LocalAddr().String()), netip.MustParseAddrPort(conn.RemoteAddr().String())
+}
+
+func expectSocket(t *testing.T, network string, source, destination netip.AddrPort, conn any) {
+	t.Helper()
+	uid, inode, err := ResolveSocketByNetlink(network, source, destination)
+	if isRefused(err) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(network, source, destination, err)
+	}
+	if uid != int32(os.Getuid()) {
+		t.Errorf("%s %s %s: got uid %d, expected %d", network, source, destination, uid, os.Getuid())
+	}
+	if expected := socketInode(t, conn); inode != expected {
+		t.Errorf("%s %s %s: got inode %d, expected %d", network, source, destination, inode, expected)
+	}
+}
+
+func socketInode(t *testing.T, conn any) uint32 {
+	file, err := conn.(interface{ File() (*os.File, error) }).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var stat syscall.Stat_t
+	err = syscall.Fstat(int(file.Fd()), &stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return uint32(stat.Ino)
+}
@@ -0,0 +1,106 @@
+package libcore
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing/common/bufio"
+	N "github.com/sagernet/sing/common/network"
+)
+
+var (
+	_ adapter.V2RayServer       = (*v2rayStatsServer)(nil)
+	_ adapter.V2RayStatsService = (*v2rayStatsServer)(nil)
+)
+
+// v2rayStatsServer counts the traffic of the outbounds passed to SetV2rayStats.
+// Counter names follow v2ray: "outbound>>>tag>>>traffic>>>uplink|downlink".
+type v2rayStatsServer struct {
+	outbounds map[string]bool
+
+	access   sync.Mutex
+	counters map[string]*atomic.Int64
+}
+
+func newV2rayStatsServer(outbounds []string) *v2rayStatsServer {
+	s := &v2rayStatsServer{
+		outbounds: make(map[string]bool),
+		counters:  make(map[string]*atomic.Int64),
+	}
+	for _, tag := range outbounds {
+		if tag != "" {
+			s.outbounds[tag] = true
+		}
+	}
+	return s
+}
+
+func (s *v2rayStatsServer) Start() error {
+	return nil
+}
+
+func (s *v2rayStatsServer) Close() error {
+	return nil
+}
+
+func (s *v2rayStatsServer) StatsService() adapter.V2RayStatsService {
+	return s
+}
+
+func (s *v2rayStatsServer) RoutedConnection(inbound string, outbound string, user string, conn net.Conn) net.Conn {
+	uplink, downlink, ok := s.outboundCounters(outbound)
+	if !ok {
+		return conn
+	}
+	// read from the inbound side is uplink, write back to it is downlink
+	return bufio.NewInt64CounterConn(conn, []*atomic.Int64{uplink}, []*atomic.Int64{downlink})
+}
+
+func (s *v2rayStatsServer) RoutedPacketConnection(inbound string, outbound string, user string, conn N.PacketConn) N.PacketConn {
+	uplink, downlink, ok := s.outboundCounters(outbound)
+	if !ok {
+		return conn
+	}
+	return bufio.NewInt64CounterPacketConn(conn, []*atomic.Int64{uplink}, []*atomic.Int64{downlink})
+}
+
+func (s *v2rayStatsServer) outboundCounters(outbound string) (uplink, downlink *atomic.Int64, ok bool) {
+	if outbound == "" || !s.outbounds[outbound] {
+		return
+	}
+	s.access.Lock()
+	defer s.access.Unlock()
+	uplink = s.loadOrCreateCounter(statsName(outbound, "uplink"))
+	downlink = s.loadOrCreateCounter(statsName(outbound, "downlink"))
+	return uplink, downlink, true
+}
+
+func (s *v2rayStatsServer) loadOrCreateCounter(name string) *atomic.Int64 {
+	counter, loaded := s.counters[name]
+	if loaded {
+		return counter
+	}
+	counter = new(atomic.Int64)
+	s.counters[name] = counter
+	return counter
+}
+
+// QueryStats returns the counter by name, resetting it to zero if reset is set.
+func (s *v2rayStatsServer) QueryStats(name string, reset bool) int64 {
+	s.access.Lock()
+	counter, loaded := s.counters[name]
+	s.access.Unlock()
+	if !loaded {
+		return 0
+	}
+	if reset {
+		return counter.Swap(0)
+	}
+	return counter.Load()
+}
+
+func statsName(tag, direct string) string {
+	return "outbound>>>" + tag + ">>>traffic>>>" + direct
+}
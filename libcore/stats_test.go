@@ -0,0 +1,100 @@
+package libcore
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/sagernet/sing/common/buf"
+	"github.com/sagernet/sing/common/bufio"
+	M "github.com/sagernet/sing/common/metadata"
+)
+
+func TestV2rayStatsRoutedConnection(t *testing.T) {
+	stats := newV2rayStatsServer([]string{"proxy", ""})
+	instance := &BoxInstance{v2api: stats}
+
+	inboundConn, conn := net.Pipe()
+	defer inboundConn.Close()
+	defer conn.Close()
+	if stats.RoutedConnection("mixed", "direct", "", conn) != conn {
+		t.Fatal("counted an outbound not enabled")
+	}
+	conn = stats.RoutedConnection("mixed", "proxy", "", conn)
+
+	// the inbound sends 5 bytes up and receives 11 bytes down
+	go inboundConn.Write([]byte("hello"))
+	_, err := io.ReadFull(conn, make([]byte, 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go io.ReadFull(inboundConn, make([]byte, 11))
+	_, err = conn.Write([]byte("hello world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uplink := instance.QueryStatsWithReset("proxy", "uplink", false); uplink != 5 {
+		t.Fatalf("uplink: got %d, expected 5", uplink)
+	}
+	// not reset by the previous query
+	if uplink := instance.QueryStats("proxy", "uplink"); uplink != 5 {
+		t.Fatalf("uplink: got %d, expected 5", uplink)
+	}
+	if uplink := instance.QueryStats("proxy", "uplink"); uplink != 0 {
+		t.Fatalf("uplink after reset: got %d, expected 0", uplink)
+	}
+	if downlink := instance.QueryStats("proxy", "downlink"); downlink != 11 {
+		t.Fatalf("downlink: got %d, expected 11", downlink)
+	}
+	if direct := instance.QueryStats("direct", "uplink"); direct != 0 {
+		t.Fatalf("direct: got %d, expected 0", direct)
+	}
+}
+
+func TestV2rayStatsRoutedPacketConnection(t *testing.T) {
+	stats := newV2rayStatsServer([]string{"proxy"})
+	instance := &BoxInstance{v2api: stats}
+
+	peer, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	udpConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udpConn.Close()
+	conn := stats.RoutedPacketConnection("mixed", "proxy", "", bufio.NewPacketConn(udpConn))
+
+	for i := 0; i < 2; i++ {
+		_, err = peer.WriteTo([]byte("request"), udpConn.LocalAddr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		buffer := buf.New()
+		_, err = conn.ReadPacket(buffer)
+		buffer.Release()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = conn.WritePacket(buf.As([]byte("ok")), M.SocksaddrFromNet(peer.LocalAddr()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uplink := instance.QueryStats("proxy", "uplink"); uplink != 14 {
+		t.Fatalf("uplink: got %d, expected 14", uplink)
+	}
+	if downlink := instance.QueryStatsWithReset("proxy", "downlink", false); downlink != 2 {
+		t.Fatalf("downlink: got %d, expected 2", downlink)
+	}
+	if downlink := instance.QueryStatsWithReset("proxy", "downlink", true); downlink != 2 {
+		t.Fatalf("downlink: got %d, expected 2", downlink)
+	}
+	if downlink := instance.QueryStats("proxy", "downlink"); downlink != 0 {
+		t.Fatalf("downlink after reset: got %d, expected 0", downlink)
+	}
+}
@@ -0,0 +1,277 @@
+package libcore
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing-box/common/urltest"
+	"github.com/sagernet/sing/common"
+	"github.com/sagernet/sing/common/bufio"
+	N "github.com/sagernet/sing/common/network"
+)
+
+type Connection struct {
+	ID          int64
+	Network     string
+	Inbound     string
+	Source      string
+	Destination string
+	Domain      string
+	Rule        string
+	Outbound    string
+	Chain       string
+	UID         int32
+	PackageName string
+	Upload      int64
+	Download    int64
+	StartTime   int64 // unix milliseconds
+}
+
+type ConnectionIterator interface {
+	Len() int32
+	HasNext() bool
+	Next() *Connection
+}
+
+var _ adapter.ClashServer = (*connectionTracker)(nil)
+
+// connectionTracker sits in the ClashServer slot of the router to see every routed connection.
+// The Clash API server, if configured, is still called and owns the mode.
+type connectionTracker struct {
+	router  adapter.Router
+	clash   adapter.ClashServer
+	history *urltest.HistoryStorage
+
+	nextID atomic.Int64
+	access sync.Mutex
+	conns  map[int64]*trackedConn
+}
+
+type trackedConn struct {
+	Connection
+	closer   io.Closer
+	upload   *atomic.Int64
+	download *atomic.Int64
+
+	source      netip.AddrPort
+	destination netip.AddrPort
+	resolved    bool
+	resolveOnce sync.Once
+}
+
+type trackerLeaver struct {
+	tracker *connectionTracker
+	id      int64
+	clash   adapter.Tracker
+}
+
+func (l *trackerLeaver) Leave() {
+	l.tracker.remove(l.id)
+	if l.clash != nil {
+		l.clash.Leave()
+	}
+}
+
+func newConnectionTracker(router adapter.Router, history *urltest.HistoryStorage) *connectionTracker {
+	return &connectionTracker{
+		router:  router,
+		clash:   router.ClashServer(),
+		history: history,
+		conns:   make(map[int64]*trackedConn),
+	}
+}
+
+// the Clash API server is started and closed by box itself
+
+func (t *connectionTracker) PreStart() error {
+	return nil
+}
+
+func (t *connectionTracker) Start() error {
+	return nil
+}
+
+func (t *connectionTracker) Close() error {
+	t.access.Lock()
+	conns := t.conns
+	t.conns = make(map[int64]*trackedConn)
+	t.access.Unlock()
+	for _, conn := range conns {
+		conn.closer.Close()
+	}
+	return nil
+}
+
+func (t *connectionTracker) Mode() string {
+	if t.clash != nil {
+		return t.clash.Mode()
+	}
+	return ""
+}
+
+func (t *connectionTracker) ModeList() []string {
+	if t.clash != nil {
+		return t.clash.ModeList()
+	}
+	return nil
+}
+
+func (t *connectionTracker) HistoryStorage() *urltest.HistoryStorage {
+	return t.history
+}
+
+func (t *connectionTracker) RoutedConnection(ctx context.Context, conn net.Conn, metadata adapter.InboundContext, matchedRule adapter.Rule) (net.Conn, adapter.Tracker) {
+	var clashTracker adapter.Tracker
+	if t.clash != nil {
+		conn, clashTracker = t.clash.RoutedConnection(ctx, conn, metadata, matchedRule)
+	}
+	tracked := t.newTrackedConn(N.NetworkTCP, metadata, matchedRule)
+	counterConn := bufio.NewInt64CounterConn(conn, []*atomic.Int64{tracked.upload}, []*atomic.Int64{tracked.download})
+	tracked.closer = counterConn
+	t.add(tracked)
+	return counterConn, &trackerLeaver{t, tracked.ID, clashTracker}
+}
+
+func (t *connectionTracker) RoutedPacketConnection(ctx context.Context, conn N.PacketConn, metadata adapter.InboundContext, matchedRule adapter.Rule) (N.PacketConn, adapter.Tracker) {
+	var clashTracker adapter.Tracker
+	if t.clash != nil {
+		conn, clashTracker = t.clash.RoutedPacketConnection(ctx, conn, metadata, matchedRule)
+	}
+	tracked := t.newTrackedConn(N.NetworkUDP, metadata, matchedRule)
+	counterConn := bufio.NewInt64CounterPacketConn(conn, []*atomic.Int64{tracked.upload}, []*atomic.Int64{tracked.download})
+	tracked.closer = counterConn
+	t.add(tracked)
+	return counterConn, &trackerLeaver{t, tracked.ID, clashTracker}
+}
+
+func (t *connectionTracker) newTrackedConn(network string, metadata adapter.InboundContext, rule adapter.Rule) *trackedConn {
+	tracked := &trackedConn{
+		Connection: Connection{
+			ID:          t.nextID.Add(1),
+			Network:     network,
+			Inbound:     metadata.Inbound,
+			Source:      metadata.Source.String(),
+			Destination: metadata.Destination.String(),
+			Domain:      metadata.Domain,
+			StartTime:   time.Now().UnixMilli(),
+		},
+		upload:   new(atomic.Int64),
+		download: new(atomic.Int64),
+		source:   metadata.Source.AddrPort(),
+	}
+	if tracked.Domain == "" && metadata.Destination.IsFqdn() {
+		tracked.Domain = metadata.Destination.Fqdn
+	}
+	if metadata.OriginDestination.IsIP() {
+		tracked.destination = metadata.OriginDestination.AddrPort()
+	} else {
+		tracked.destination = metadata.Destination.AddrPort()
+	}
+	if metadata.ProcessInfo != nil {
+		tracked.UID = metadata.ProcessInfo.UserId
+		tracked.PackageName = metadata.ProcessInfo.PackageName
+		tracked.resolved = true
+	}
+
+	// follow groups down to the real outbound, same as the Clash API
+	var next string
+	if rule != nil {
+		tracked.Rule = rule.String()
+		next = rule.Outbound()
+	} else if defaultOutbound, err := t.router.DefaultOutbound(network); err == nil {
+		next = defaultOutbound.Tag()
+	}
+	var chain []string
+	for next != "" && !common.Contains(chain, next) {
+		chain = append(chain, next)
+		detour, loaded := t.router.Outbound(next)
+		if !loaded {
+			break
+		}
+		group, isGroup := detour.(adapter.OutboundGroup)
+		if !isGroup {
+			break
+		}
+		next = group.Now()
+	}
+	if len(chain) > 0 {
+		tracked.Outbound = chain[len(chain)-1]
+	}
+	tracked.Chain = strings.Join(chain, " -> ")
+	return tracked
+}
+
+func (t *connectionTracker) add(tracked *trackedConn) {
+	t.access.Lock()
+	t.conns[tracked.ID] = tracked
+	t.access.Unlock()
+}
+
+func (t *connectionTracker) remove(id int64) *trackedConn {
+	t.access.Lock()
+	defer t.access.Unlock()
+	tracked, loaded := t.conns[id]
+	if !loaded {
+		return nil
+	}
+	delete(t.conns, id)
+	return tracked
+}
+
+// Connections returns a snapshot of live connections ordered by start time.
+func (t *connectionTracker) Connections() []*Connection {
+	t.access.Lock()
+	trackedList := make([]*trackedConn, 0, len(t.conns))
+	for _, tracked := range t.conns {
+		trackedList = append(trackedList, tracked)
+	}
+	t.access.Unlock()
+	sort.Slice(trackedList, func(i, j int) bool {
+		return trackedList[i].ID < trackedList[j].ID
+	})
+	connections := make([]*Connection, 0, len(trackedList))
+	for _, tracked := range trackedList {
+		connections = append(connections, tracked.snapshot())
+	}
+	return connections
+}
+
+func (t *connectionTracker) CloseConnection(id int64) bool {
+	tracked := t.remove(id)
+	if tracked == nil {
+		return false
+	}
+	tracked.closer.Close()
+	return true
+}
+
+func (c *trackedConn) snapshot() *Connection {
+	if !c.resolved {
+		c.resolveOnce.Do(c.resolveProcess)
+	}
+	connection := c.Connection
+	connection.Upload = c.upload.Load()
+	connection.Download = c.download.Load()
+	return &connection
+}
+
+// resolveProcess looks up the owner lazily, find_process may be off in the config.
+func (c *trackedConn) resolveProcess() {
+	if intfBox == nil || !c.source.IsValid() {
+		return
+	}
+	info, err := boxPlatformInterfaceInstance.FindProcessInfo(context.Background(), c.Network, c.source, c.destination)
+	if err != nil {
+		return
+	}
+	c.UID = info.UserId
+	c.PackageName = info.PackageName
+}
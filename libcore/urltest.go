@@ -0,0 +1,189 @@
+package libcore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"libcore/device"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing-box/common/urltest"
+	C "github.com/sagernet/sing-box/constant"
+	"github.com/sagernet/sing/common"
+	"github.com/sagernet/sing/common/batch"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+)
+
+const (
+	// UrlTestStandardRTT measures from the proxy connection being ready to the first response byte.
+	UrlTestStandardRTT int32 = iota
+	// UrlTestStandardFirstByte measures from the start to the first response byte.
+	UrlTestStandardFirstByte
+	// UrlTestStandardHandshake measures from the start to the end of the proxy and TLS handshakes.
+	UrlTestStandardHandshake
+)
+
+func UrlTest(i *BoxInstance, link string, timeout int32) (latency int32, err error) {
+	return UrlTestOutbound(i, "", link, timeout, UrlTestStandardRTT)
+}
+
+// UrlTestOutbound tests the outbound of the instance by tag, the default outbound if tag is empty.
+// If the instance is nil, the main instance is tested.
+func UrlTestOutbound(i *BoxInstance, tag string, link string, timeout int32, standard int32) (latency int32, err error) {
+	defer device.DeferPanicToError("box.UrlTest", func(err_ error) { err = err_ })
+	if i == nil {
+		// test current
+		i = mainInstance
+	}
+	if i == nil {
+		return 0, errors.New("no running instance")
+	}
+	// pooled connections only skip the handshakes RTT does not count
+	var dialer N.Dialer
+	if standard == UrlTestStandardRTT {
+		dialer, err = i.outboundDialer(tag)
+	} else {
+		dialer, err = i.outbound(tag)
+	}
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Millisecond)
+	defer cancel()
+	return urlTest(ctx, dialer, link, standard)
+}
+
+type UrlTestCallback interface {
+	OnResult(tag string, latency int32, errorMessage string)
+}
+
+// UrlTestGroup tests the outbounds by tags separated by "\n" in parallel, every proxy outbound if tags is empty.
+// Results are reported as each test finishes, the call returns when all are done.
+func (b *BoxInstance) UrlTestGroup(tags string, link string, timeout int32, concurrency int32, callback UrlTestCallback) (err error) {
+	defer device.DeferPanicToError("box.UrlTestGroup", func(err_ error) { err = err_ })
+	if callback == nil {
+		return errors.New("missing callback")
+	}
+	var outbounds []adapter.Outbound
+	if tags == "" {
+		outbounds = common.Filter(b.Router().Outbounds(), func(it adapter.Outbound) bool {
+			if _, isGroup := it.(adapter.OutboundGroup); isGroup {
+				return false
+			}
+			switch it.Type() {
+			case C.TypeDirect, C.TypeBlock, C.TypeDNS:
+				return false
+			}
+			return true
+		})
+	} else {
+		for _, tag := range common.Uniq(strings.Split(tags, "\n")) {
+			if tag == "" {
+				continue
+			}
+			outbound, loaded := b.Router().Outbound(tag)
+			if !loaded {
+				return fmt.Errorf("outbound not found: %s", tag)
+			}
+			outbounds = append(outbounds, outbound)
+		}
+	}
+	if concurrency <= 0 {
+		concurrency = 10
+	}
+	history := b.urlTestHistory
+	var callbackAccess sync.Mutex
+	group, _ := batch.New(context.Background(), batch.WithConcurrencyNum[any](int(concurrency)))
+	for _, outbound := range outbounds {
+		outbound := outbound
+		group.Go(outbound.Tag(), func() (any, error) {
+			// the recover of the caller does not cover the batch goroutines
+			defer device.DeferPanicToError("box.UrlTestGroup-go", func(err error) { log.Println(err) })
+			latency, err := urlTestGroupItem(outbound, b.pool.Dialer(outbound), link, timeout)
+			var errorMessage string
+			if err != nil {
+				errorMessage = err.Error()
+				history.DeleteURLTestHistory(outbound.Tag())
+			} else {
+				delay := latency
+				if delay > math.MaxUint16 {
+					delay = math.MaxUint16
+				}
+				history.StoreURLTestHistory(outbound.Tag(), &urltest.History{
+					Time:  time.Now(),
+					Delay: uint16(delay),
+				})
+			}
+			callbackAccess.Lock()
+			callback.OnResult(outbound.Tag(), latency, errorMessage)
+			callbackAccess.Unlock()
+			return nil, nil
+		})
+	}
+	group.Wait()
+	return nil
+}
+
+// urlTestGroupItem reports a panic of the outbound as its error.
+func urlTestGroupItem(outbound adapter.Outbound, dialer N.Dialer, link string, timeout int32) (latency int32, err error) {
+	defer device.DeferPanicToError("box.UrlTestGroup "+outbound.Tag(), func(err_ error) { err = err_ })
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Millisecond)
+	defer cancel()
+	return urlTest(ctx, dialer, link, UrlTestStandardRTT)
+}
+
+func urlTest(ctx context.Context, dialer N.Dialer, link string, standard int32) (int32, error) {
+	var start, ready, firstByte time.Time
+	trace := &httptrace.ClientTrace{
+		GotConn: func(httptrace.GotConnInfo) {
+			ready = time.Now()
+		},
+		GotFirstResponseByte: func() {
+			firstByte = time.Now()
+		},
+	}
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), http.MethodHead, link, nil)
+	if err != nil {
+		return 0, err
+	}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (conn net.Conn, err error) {
+			// dialed in a goroutine of the transport, out of reach of the callers' recover
+			defer device.DeferPanicToError("box.UrlTest dial", func(err_ error) { err = err_ })
+			return dialer.DialContext(ctx, network, M.ParseSocksaddr(addr))
+		},
+		DisableKeepAlives: true,
+	}
+	defer transport.CloseIdleConnections()
+	client := http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	start = time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	var latency time.Duration
+	switch standard {
+	case UrlTestStandardFirstByte:
+		latency = firstByte.Sub(start)
+	case UrlTestStandardHandshake:
+		latency = ready.Sub(start)
+	default:
+		latency = firstByte.Sub(ready)
+	}
+	return int32(latency.Milliseconds()), nil
+}